@@ -4,14 +4,20 @@ import (
 	"../idl"
 	"flag"
 	"fmt"
+	"io/fs"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 )
 
-func checkErr(err error, what string) {
+func checkErr(err error, what string, source func(string) []byte) {
 	if err != nil {
-		fmt.Errorf("error! %s (%s)", err, what)
+		if list, ok := err.(idl.ErrorList); ok {
+			list.Render(os.Stderr, source)
+		}
+		fmt.Fprintf(os.Stderr, "error! %s (%s)\n", err, what)
 		os.Exit(-1)
 	}
 }
@@ -19,6 +25,7 @@ func checkErr(err error, what string) {
 func main() {
 	file := flag.String("file", "dds_dcps.idl", "file to parse")
 	baseModule := flag.String("module", "Dds", "base module to generate")
+	includes := flag.String("I", "", "comma separated directories to search for included files")
 	flag.Parse()
 
 	if file == nil {
@@ -27,52 +34,102 @@ func main() {
 	}
 
 	b, err := ioutil.ReadFile(*file)
-	checkErr(err, "reading file")
-	tokens, err := idl.Lex(b)
-	checkErr(err, "lexing")
-	module, err := idl.Parse(tokens)
-	checkErr(err, "parsing")
+	checkErr(err, "reading file", nil)
+	// Files are found from the root, so that any path works.
+	opts := idl.ParseOptions{FS: os.DirFS("/")}
+	if *includes != "" {
+		for _, dir := range strings.Split(*includes, ",") {
+			opts.IncludePaths = append(opts.IncludePaths, rootPath(dir))
+		}
+	}
+	source := fileSource(opts.FS)
+	// Errors are rendered when parsing fails, but warnings only come here.
+	opts.DiagnosticSink = func(d *idl.Diagnostic) {
+		if d.Severity == idl.SeverityWarning {
+			d.Render(os.Stderr, source)
+		}
+	}
+	tokens, err := idl.LexWithOptions(rootPath(*file), b, opts)
+	checkErr(err, "lexing", source)
+	module, err := idl.ParseWithOptions(tokens, opts)
+	checkErr(err, "parsing", source)
 	module.Name = *baseModule
 	generateModule(module)
 }
 
+// Turn a path into one in the FS of the whole file system.
+func rootPath(name string) string {
+	abs, err := filepath.Abs(name)
+	checkErr(err, "finding file", nil)
+	return strings.TrimPrefix(filepath.ToSlash(abs), "/")
+}
+
+// Look up the content of the files that diagnostics are in, from where they
+// were read.
+func fileSource(fsys fs.FS) func(string) []byte {
+	return func(name string) []byte {
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil
+		}
+		return b
+	}
+}
+
 // Turn an IDL type (like "sequence<Foo>") into a Go type ("[]Foo")
 func idlTypeToGoType(idlType idl.Type) string {
 	rtype := ""
 
-	if idlType.Quantity != nil {
-		rtype = fmt.Sprintf("[%d]", *idlType.Quantity)
+	for _, dim := range idlType.Dimensions {
+		rtype += fmt.Sprintf("[%d]", dim.Value)
 	}
 
 	n := idlType.Name
-	if idx := strings.Index(n, "::"); idx >= 0 {
+	if idx := strings.LastIndex(n, "::"); idx >= 0 {
 		// strip off namespace prefix
 		n = n[idx+2:]
 	}
 
-	if n == "unsigned long" {
-		rtype += "uint32"
-	} else if n == "boolean" {
+	switch idlType.Primitive {
+	case idl.PrimitiveBoolean:
 		rtype += "bool"
-	} else if n == "long long" {
-		rtype += "int64"
-	} else if n == "long" {
+	case idl.PrimitiveOctet, idl.PrimitiveUInt8:
+		rtype += "byte"
+	case idl.PrimitiveChar, idl.PrimitiveInt8:
+		rtype += "int8"
+	case idl.PrimitiveWChar:
+		rtype += "rune"
+	case idl.PrimitiveShort, idl.PrimitiveInt16:
+		rtype += "int16"
+	case idl.PrimitiveUnsignedShort, idl.PrimitiveUInt16:
+		rtype += "uint16"
+	case idl.PrimitiveLong, idl.PrimitiveInt32:
 		rtype += "int32"
-	} else if n == "float" {
+	case idl.PrimitiveUnsignedLong, idl.PrimitiveUInt32:
+		rtype += "uint32"
+	case idl.PrimitiveLongLong, idl.PrimitiveInt64:
+		rtype += "int64"
+	case idl.PrimitiveUnsignedLongLong, idl.PrimitiveUInt64:
+		rtype += "uint64"
+	case idl.PrimitiveFloat:
 		rtype += "float32"
-	} else if n == "double" {
+	case idl.PrimitiveDouble, idl.PrimitiveLongDouble:
 		rtype += "float64"
-	} else if n == "sequence" {
-		nestedType := idl.Type{Name: idlType.TemplateParameters[0].Name}
-		if len(idlType.TemplateParameters) == 1 {
-			rtype += fmt.Sprintf("[]%s", idlTypeToGoType(nestedType))
-		} else if len(idlType.TemplateParameters) == 2 {
-			rtype += fmt.Sprintf("[%s]%s", idlType.TemplateParameters[1].Name, idlTypeToGoType(nestedType))
+	case idl.PrimitiveString, idl.PrimitiveWString:
+		rtype += "string"
+	default:
+		if key, value, ok := idlType.MapTypes(); ok {
+			rtype += fmt.Sprintf("map[%s]%s", idlTypeToGoType(key), idlTypeToGoType(value))
+		} else if bound, ok := idlType.SequenceBound(); ok {
+			nestedType := idlType.TemplateParameters[0]
+			if bound == 0 {
+				rtype += fmt.Sprintf("[]%s", idlTypeToGoType(nestedType))
+			} else {
+				rtype += fmt.Sprintf("[%d]%s", bound, idlTypeToGoType(nestedType))
+			}
 		} else {
-			panic("too many params")
+			rtype += n
 		}
-	} else {
-		rtype += n
 	}
 
 	return rtype
@@ -87,6 +144,100 @@ func identifierToGoIdentifier(identifier string) string {
 	return nid
 }
 
+// Print the comments of an IDL declaration as a Go doc comment. Trailing
+// comments are used if there are no leading ones.
+func printDoc(indent string, doc idl.Comments) {
+	text := doc.Text()
+	if text == "" {
+		text = doc.TrailingText()
+	}
+	if text == "" {
+		return
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		if line == "" {
+			fmt.Printf("%s//\n", indent)
+		} else {
+			fmt.Printf("%s// %s\n", indent, line)
+		}
+	}
+}
+
+// The smallest unsigned Go integer type with at least the given bits.
+func goUintType(bits int) string {
+	switch {
+	case bits <= 8:
+		return "uint8"
+	case bits <= 16:
+		return "uint16"
+	case bits <= 32:
+		return "uint32"
+	}
+	return "uint64"
+}
+
+// Return the fields of a bitset, starting with those of the bitsets it
+// extends.
+func bitsetFields(b idl.Bitset) []idl.BitField {
+	fields := b.Fields
+	for base := b.Base; base != nil; base = base.Base {
+		fields = append(append([]idl.BitField{}, base.Fields...), fields...)
+	}
+	return fields
+}
+
+// Write a getter and a setter that unpack and pack a field of a bitset.
+func generateBitField(bitset string, f idl.BitField) {
+	name := identifierToGoIdentifier(f.Name)
+	goType := idlTypeToGoType(f.Type)
+	width := f.Width.Value
+	mask := fmt.Sprintf("0x%x", uint64(1)<<width-1)
+
+	printDoc("", f.Doc)
+	fmt.Printf("func (b %s) %s() %s {\n", bitset, name, goType)
+	switch {
+	case f.Type.Primitive == idl.PrimitiveBoolean:
+		fmt.Printf("\treturn uint64(b)>>%d&1 != 0\n", f.Offset)
+	case strings.HasPrefix(goType, "int"):
+		// Shift the field to the top, so shifting it back down extends the
+		// sign.
+		fmt.Printf("\treturn %s(int64(uint64(b)<<%d) >> %d)\n", goType, 64-f.Offset-width, 64-width)
+	default:
+		fmt.Printf("\treturn %s(uint64(b) >> %d & %s)\n", goType, f.Offset, mask)
+	}
+	fmt.Printf("}\n")
+
+	fmt.Printf("func (b *%s) Set%s(v %s) {\n", bitset, name, goType)
+	if f.Type.Primitive == idl.PrimitiveBoolean {
+		fmt.Printf("\tbit := uint64(0)\n")
+		fmt.Printf("\tif v {\n")
+		fmt.Printf("\t\tbit = 1\n")
+		fmt.Printf("\t}\n")
+		fmt.Printf("\t*b = %s(uint64(*b)&^(1<<%d) | bit<<%d)\n", bitset, f.Offset, f.Offset)
+	} else {
+		fmt.Printf("\t*b = %s(uint64(*b)&^(%s<<%d) | (uint64(v)&%s)<<%d)\n", bitset, mask, f.Offset, mask, f.Offset)
+	}
+	fmt.Printf("}\n")
+}
+
+// Write a constant's value as Go.
+func goValue(v idl.Value) string {
+	switch v.Kind {
+	case idl.ValueFixed:
+		return v.Fixed.FloatString(v.Scale)
+	case idl.ValueString, idl.ValueWString:
+		return strconv.Quote(v.Str)
+	case idl.ValueChar, idl.ValueWChar:
+		return strconv.QuoteRune(v.Char)
+	case idl.ValueBoolean:
+		return strconv.FormatBool(v.Bool)
+	case idl.ValueEnumerator:
+		return v.Int.String()
+	}
+	return v.String()
+}
+
 // ### todo: write this to disk, not stdout. nest the generated code in
 // directories, so:
 //
@@ -102,13 +253,15 @@ func generateModule(m idl.Module) {
 
 	fmt.Printf("\n\n")
 	for _, t := range m.Constants {
-		fmt.Printf("const %s = %s\n", t.Name, t.Value)
+		printDoc("", t.Doc)
+		fmt.Printf("const %s = %s\n", t.Name, goValue(t.Value))
 	}
 
 	fmt.Printf("\n\n")
 
 	fmt.Printf("// TypeDefs\n")
 	for _, t := range m.TypeDefs {
+		printDoc("", t.Doc)
 		fmt.Printf("type %s %s\n", t.Name, idlTypeToGoType(t.Type))
 	}
 	fmt.Printf("\n\n")
@@ -116,10 +269,12 @@ func generateModule(m idl.Module) {
 	// ### this needs a lot of fleshing out i'm sure
 	fmt.Printf("// Unions\n")
 	for _, t := range m.Unions {
+		printDoc("", t.Doc)
 		fmt.Printf("type %s struct {\n", t.Name)
 		fmt.Printf("}\n")
 
 		for _, t2 := range t.Members {
+			printDoc("", t2.Doc)
 			fmt.Printf("func (u *%s) %s() %s {", t.Name, identifierToGoIdentifier(t2.MemberName), idlTypeToGoType(t2.MemberType))
 			fmt.Printf("return %s{}", idlTypeToGoType(t2.MemberType))
 			fmt.Printf("}\n")
@@ -129,23 +284,53 @@ func generateModule(m idl.Module) {
 
 	fmt.Printf("// Enums\n")
 	for _, t := range m.Enums {
+		printDoc("", t.Doc)
 		fmt.Printf("type %s int32\n", t.Name)
 		fmt.Printf("const (\n")
 
-		for idx, t2 := range t.Members {
-			if idx == 0 {
-				fmt.Printf("\t%s%s = iota\n", t.Name, t2.Name)
-			} else {
-				fmt.Printf("\t%s%s\n", t.Name, t2.Name)
-			}
+		for _, t2 := range t.Members {
+			printDoc("\t", t2.Doc)
+			fmt.Printf("\t%s%s %s = %d\n", t.Name, t2.Name, t.Name, t2.Value)
 		}
 
 		fmt.Printf(")\n")
 	}
 	fmt.Printf("\n\n")
 
+	fmt.Printf("// Bitmasks\n")
+	for _, t := range m.Bitmasks {
+		bits := 32
+		if t.BitBound != nil && t.BitBound.Value > 0 {
+			bits = t.BitBound.Value
+		}
+		printDoc("", t.Doc)
+		fmt.Printf("type %s %s\n", t.Name, goUintType(bits))
+		fmt.Printf("const (\n")
+
+		for _, t2 := range t.Members {
+			printDoc("\t", t2.Doc)
+			fmt.Printf("\t%s%s %s = 1 << %d\n", t.Name, t2.Name, t.Name, t2.Position)
+		}
+
+		fmt.Printf(")\n")
+	}
+	fmt.Printf("\n\n")
+
+	fmt.Printf("// Bitsets\n")
+	for _, t := range m.Bitsets {
+		printDoc("", t.Doc)
+		fmt.Printf("type %s %s\n", t.Name, goUintType(t.Bits))
+		for _, t2 := range bitsetFields(t) {
+			if t2.Name != "" {
+				generateBitField(t.Name, t2)
+			}
+		}
+	}
+	fmt.Printf("\n\n")
+
 	fmt.Printf("// Structs\n")
 	for _, t := range m.Structs {
+		printDoc("", t.Doc)
 		fmt.Printf("type %s struct {\n", t.Name)
 		for _, t2 := range t.Inherits {
 			fmt.Printf("\t%s\n", t2)
@@ -153,6 +338,7 @@ func generateModule(m idl.Module) {
 		}
 
 		for _, t2 := range t.Members {
+			printDoc("\t", t2.Doc)
 			fmt.Printf("\t%s %s\n", identifierToGoIdentifier(t2.Name), idlTypeToGoType(t2.Type))
 
 		}
@@ -4,20 +4,28 @@ import (
 	"../idl"
 	"flag"
 	"fmt"
+	"io/fs"
 	"io/ioutil"
+	"log/slog"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
-func checkErr(err error, what string) {
+func checkErr(err error, what string, source func(string) []byte) {
 	if err != nil {
-		fmt.Errorf("error! %s (%s)", err, what)
+		if list, ok := err.(idl.ErrorList); ok {
+			list.Render(os.Stderr, source)
+		}
+		fmt.Fprintf(os.Stderr, "error! %s (%s)\n", err, what)
 		os.Exit(-1)
 	}
 }
 
 func main() {
 	file := flag.String("file", "dds_dcps.idl", "file to parse")
+	debug := flag.Bool("debug", false, "trace the lexer and parser on stderr")
+	includes := flag.String("I", "", "comma separated directories to search for included files")
 	flag.Parse()
 
 	if file == nil {
@@ -26,14 +34,50 @@ func main() {
 	}
 
 	b, err := ioutil.ReadFile(*file)
-	checkErr(err, "reading file")
-	tokens, err := idl.Lex(b)
-	checkErr(err, "lexing")
-	module, err := idl.Parse(tokens)
-	checkErr(err, "parsing")
+	checkErr(err, "reading file", nil)
+	// Files are found from the root, so that any path works.
+	opts := idl.ParseOptions{FS: os.DirFS("/")}
+	if *includes != "" {
+		for _, dir := range strings.Split(*includes, ",") {
+			opts.IncludePaths = append(opts.IncludePaths, rootPath(dir))
+		}
+	}
+	if *debug {
+		opts.Logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	}
+	source := fileSource(opts.FS)
+	// Errors are rendered when parsing fails, but warnings only come here.
+	opts.DiagnosticSink = func(d *idl.Diagnostic) {
+		if d.Severity == idl.SeverityWarning {
+			d.Render(os.Stderr, source)
+		}
+	}
+	tokens, err := idl.LexWithOptions(rootPath(*file), b, opts)
+	checkErr(err, "lexing", source)
+	module, err := idl.ParseWithOptions(tokens, opts)
+	checkErr(err, "parsing", source)
 	printModule(module)
 }
 
+// Look up the content of the files that diagnostics are in, from where they
+// were read.
+func fileSource(fsys fs.FS) func(string) []byte {
+	return func(name string) []byte {
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil
+		}
+		return b
+	}
+}
+
+// Turn a path into one in the FS of the whole file system.
+func rootPath(name string) string {
+	abs, err := filepath.Abs(name)
+	checkErr(err, "finding file", nil)
+	return strings.TrimPrefix(filepath.ToSlash(abs), "/")
+}
+
 var recurse = 0
 
 func printModule(m idl.Module) {
@@ -49,41 +93,97 @@ func printModule(m idl.Module) {
 	fmt.Printf("%s\t%s Interfaces:\n", tabs, m.Name)
 	for _, t := range m.Interfaces {
 		fmt.Printf("%s\t\t%s (: %s)\n", tabs, t.Name, strings.Join(t.Inherits, ", "))
+		for _, t2 := range t.Attributes {
+			readonly := ""
+			if t2.Readonly {
+				readonly = "readonly "
+			}
+			fmt.Printf("%s\t\t\t%s%sattribute %s %s\n", tabs, annotations(t2.Annotations), readonly, t2.Type, t2.Name)
+		}
 		for _, t2 := range t.Methods {
-			fmt.Printf("%s\t\t\t%s %s(%s)\n", tabs, t2.ReturnValue, t2.Name, t2.Parameters)
+			oneway := ""
+			if t2.Oneway {
+				oneway = "oneway "
+			}
+			raises := ""
+			if len(t2.Raises) > 0 {
+				raises = " raises (" + strings.Join(t2.Raises, ", ") + ")"
+			}
+			fmt.Printf("%s\t\t\t%s%s%s %s(%s)%s\n", tabs, annotations(t2.Annotations), oneway, t2.ReturnValue, t2.Name, t2.Parameters, raises)
 		}
 	}
+	fmt.Printf("%s\t%s Forward declarations:\n", tabs, m.Name)
+	for _, t := range m.ForwardDecls {
+		fmt.Printf("%s\t\t%s %s\n", tabs, t.Kind, t.Name)
+	}
 	fmt.Printf("%s\t%s Constants:\n", tabs, m.Name)
 	for _, t := range m.Constants {
-		fmt.Printf("%s\t\t%s (%s)\n", tabs, t.Name, t.Type)
+		fmt.Printf("%s\t\t%s (%s) = %s\n", tabs, t.Name, t.Type, t.Value)
 	}
 	fmt.Printf("%s\t%s TypeDefs:\n", tabs, m.Name)
 	for _, t := range m.TypeDefs {
-		fmt.Printf("%s\t\t%s (%s)\n", tabs, t.Name, t.Type)
+		fmt.Printf("%s\t\t%s%s (%s)\n", tabs, annotations(t.Annotations), t.Name, t.Type)
 	}
 	fmt.Printf("%s\t%s Enums:\n", tabs, m.Name)
 	for _, t := range m.Enums {
+		fmt.Printf("%s\t\t%s%s\n", tabs, annotations(t.Annotations), t.Name)
+		for _, t2 := range t.Members {
+			fmt.Printf("%s\t\t\t%s = %d\n", tabs, t2.Name, t2.Value)
+		}
+	}
+	fmt.Printf("%s\t%s Bitmasks:\n", tabs, m.Name)
+	for _, t := range m.Bitmasks {
 		fmt.Printf("%s\t\t%s\n", tabs, t.Name)
 		for _, t2 := range t.Members {
-			fmt.Printf("%s\t\t\t%s (%s)\n", tabs, t2.Name, t2.Type)
+			fmt.Printf("%s\t\t\t%s @ bit %d\n", tabs, t2.Name, t2.Position)
+		}
+	}
+	fmt.Printf("%s\t%s Bitsets:\n", tabs, m.Name)
+	for _, t := range m.Bitsets {
+		fmt.Printf("%s\t\t%s (: %s, %d bits)\n", tabs, t.Name, t.Inherits, t.Bits)
+		for _, t2 := range t.Fields {
+			fmt.Printf("%s\t\t\t%s (%s) @ bits %d..%d\n", tabs, t2.Name, t2.Type, t2.Offset, t2.Offset+t2.Width.Value-1)
 		}
 	}
 	fmt.Printf("%s\t%s Unions:\n", tabs, m.Name)
 	for _, t := range m.Unions {
-		fmt.Printf("%s\t\t%s (on type %s)\n", tabs, t.Name, t.Discriminant)
+		fmt.Printf("%s\t\t%s%s (on type %s)\n", tabs, annotations(t.Annotations), t.Name, t.Discriminant)
 		for _, t2 := range t.Members {
-			fmt.Printf("%s\t\t\tcase %s (%s %s)\n", tabs, t2.CaseValue, t2.MemberType, t2.MemberName)
+			labels := []string{}
+			for _, l := range t2.Labels {
+				labels = append(labels, "case "+l.Value.String())
+			}
+			if t2.IsDefault {
+				labels = append(labels, "default")
+			}
+			fmt.Printf("%s\t\t\t%s (%s%s %s)\n", tabs, strings.Join(labels, ", "), annotations(t2.Annotations), t2.MemberType, t2.MemberName)
 		}
 	}
-	fmt.Printf("%s\t%s Structs:\n", tabs, m.Name)
-	for _, t := range m.Structs {
+	fmt.Printf("%s\t%s Exceptions:\n", tabs, m.Name)
+	for _, t := range m.Exceptions {
 		fmt.Printf("%s\t\t%s\n", tabs, t.Name)
 		for _, t2 := range t.Members {
 			fmt.Printf("%s\t\t\t%s (%s)\n", tabs, t2.Name, t2.Type)
 		}
 	}
+	fmt.Printf("%s\t%s Structs:\n", tabs, m.Name)
+	for _, t := range m.Structs {
+		fmt.Printf("%s\t\t%s%s\n", tabs, annotations(t.Annotations), t.Name)
+		for _, t2 := range t.Members {
+			fmt.Printf("%s\t\t\t%s%s (%s)\n", tabs, annotations(t2.Annotations), t2.Name, t2.Type)
+		}
+	}
 
 	for _, t := range m.Modules {
 		printModule(t)
 	}
 }
+
+// Write annotations before the declaration they apply to, e.g. "@key ".
+func annotations(as idl.Annotations) string {
+	s := ""
+	for _, a := range as {
+		s += a.String() + " "
+	}
+	return s
+}
@@ -0,0 +1,304 @@
+package idl
+
+// The annotations defined by the IDL and DDS specifications, which are known
+// without being declared. Their parameters are not checked here.
+var builtinAnnotations = map[string]bool{
+	"id":                   true,
+	"autoid":               true,
+	"optional":             true,
+	"position":             true,
+	"value":                true,
+	"extensibility":        true,
+	"final":                true,
+	"appendable":           true,
+	"mutable":              true,
+	"key":                  true,
+	"must_understand":      true,
+	"default_literal":      true,
+	"default":              true,
+	"range":                true,
+	"min":                  true,
+	"max":                  true,
+	"unit":                 true,
+	"bit_bound":            true,
+	"external":             true,
+	"nested":               true,
+	"verbatim":             true,
+	"service":              true,
+	"oneway":               true,
+	"ami":                  true,
+	"hashid":               true,
+	"default_nested":       true,
+	"ignore_literal_names": true,
+	"try_construct":        true,
+	"non_serialized":       true,
+	"data_representation":  true,
+	"topic":                true,
+}
+
+// Check the annotations applied to the modules and interfaces in a module
+// (including the interfaces' operations, attributes and parameters), and the
+// modules inside it.
+func (r *resolver) checkModuleAnnotations(m *Module, scope string) {
+	for i := range m.Modules {
+		mod := &m.Modules[i]
+		r.checkAnnotations(mod.Annotations, scope)
+		r.checkModuleAnnotations(mod, scopedName(scope, mod.Name))
+	}
+	for i := range m.Interfaces {
+		iface := &m.Interfaces[i]
+		r.checkAnnotations(iface.Annotations, scope)
+
+		ifaceScope := scopedName(scope, iface.Name)
+		for _, method := range iface.Methods {
+			r.checkAnnotations(method.Annotations, ifaceScope)
+			for _, param := range method.Parameters {
+				r.checkAnnotations(param.Annotations, ifaceScope)
+			}
+		}
+		for _, a := range iface.Attributes {
+			r.checkAnnotations(a.Annotations, ifaceScope)
+		}
+	}
+}
+
+// Check the annotations applied to the declarations in a scope, and to their
+// members.
+func (r *resolver) checkScopeAnnotations(d *Declarations, scope string) {
+	for _, s := range d.Structs {
+		r.checkAnnotations(s.Annotations, scope)
+		for _, m := range s.Members {
+			r.checkAnnotations(m.Annotations, scopedName(scope, s.Name))
+		}
+	}
+	for _, u := range d.Unions {
+		r.checkAnnotations(u.Annotations, scope)
+		for _, m := range u.Members {
+			r.checkAnnotations(m.Annotations, scopedName(scope, u.Name))
+		}
+	}
+	for _, e := range d.Enums {
+		r.checkAnnotations(e.Annotations, scope)
+		for _, m := range e.Members {
+			r.checkAnnotations(m.Annotations, scope)
+		}
+	}
+	for _, e := range d.Exceptions {
+		r.checkAnnotations(e.Annotations, scope)
+		for _, m := range e.Members {
+			r.checkAnnotations(m.Annotations, scopedName(scope, e.Name))
+		}
+	}
+	for _, t := range d.TypeDefs {
+		r.checkAnnotations(t.Annotations, scope)
+	}
+	for _, c := range d.Constants {
+		r.checkAnnotations(c.Annotations, scope)
+	}
+	for _, b := range d.Bitmasks {
+		r.checkAnnotations(b.Annotations, scope)
+		for _, v := range b.Members {
+			r.checkAnnotations(v.Annotations, scope)
+		}
+	}
+	for _, b := range d.Bitsets {
+		r.checkAnnotations(b.Annotations, scope)
+		for _, f := range b.Fields {
+			r.checkAnnotations(f.Annotations, scopedName(scope, b.Name))
+		}
+	}
+
+	// Declarations that are not used are checked all the same.
+	for i := range d.AnnotationDecls {
+		s := r.annotations[scopedName(scope, d.AnnotationDecls[i].Name)]
+		if s != nil && s.annotation == &d.AnnotationDecls[i] {
+			r.resolveAnnotationDecl(s)
+		}
+	}
+}
+
+func (r *resolver) checkAnnotations(as Annotations, scope string) {
+	for i := range as {
+		r.checkAnnotation(&as[i], scope)
+	}
+}
+
+// Check an annotation applied in the given scope against its declaration, and
+// compute the values of its parameters.
+func (r *resolver) checkAnnotation(a *Annotation, scope string) {
+	if r.checkedAnnotations[a] {
+		return
+	}
+	r.checkedAnnotations[a] = true
+
+	s := lookupIn(r.annotations, a.Name, scope)
+	if s == nil {
+		if !builtinAnnotations[a.Name] {
+			r.unknownAnnotation(a)
+		}
+		return
+	}
+
+	decl := s.annotation
+	r.resolveAnnotationDecl(s)
+	a.Decl = decl
+	a.Values = make(map[string]Value)
+
+	// Parameters that were given, whether or not their values are valid
+	given := make(map[string]Position)
+
+	if len(a.Params) > 0 {
+		// @MyAnno(5) gives the only parameter.
+		if len(decl.Members) != 1 || len(a.Params) != 1 {
+			r.errorf(CodeTypeMismatch, a.Pos, "parameters of @%s must be given by name", a.Name)
+			return
+		}
+		given[decl.Members[0].Name] = a.Pos
+		r.annotationValue(a, s, 0, a.Params[0], scope)
+	}
+
+	for _, np := range a.NamedParams {
+		i := annotationMemberIndex(decl, np.Name)
+		if i < 0 {
+			r.errorf(CodeUnknownName, np.Pos, "annotation @%s has no parameter %s", a.Name, np.Name)
+			continue
+		}
+		if prev, ok := given[np.Name]; ok {
+			r.duplicate(np.Pos, prev, "parameter %s of @%s is given more than once", np.Name, a.Name)
+			continue
+		}
+		given[np.Name] = np.Pos
+		r.annotationValue(a, s, i, np.Expr, scope)
+	}
+
+	for _, m := range decl.Members {
+		if _, ok := given[m.Name]; ok {
+			continue
+		}
+		if m.Default == nil {
+			r.errorf(CodeMissingParameter, a.Pos, "@%s needs a value for parameter %s", a.Name, m.Name)
+			continue
+		}
+		if m.DefaultValue.Kind != ValueInvalid {
+			a.Values[m.Name] = m.DefaultValue
+		}
+	}
+}
+
+// Compute the value of a parameter of an annotation, given in the scope the
+// annotation is applied in.
+func (r *resolver) annotationValue(a *Annotation, s *symbol, i int, e Expr, scope string) {
+	t := s.paramTypes[i]
+	if t == nil {
+		return
+	}
+	v, ok := r.eval(e, scope, *t)
+	if ok {
+		v, ok = r.convert(v, *t, e.Pos())
+	}
+	if ok {
+		a.Values[s.annotation.Members[i].Name] = v
+	}
+}
+
+func annotationMemberIndex(decl *AnnotationDecl, name string) int {
+	for i, m := range decl.Members {
+		if m.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Work out the types of the parameters of an annotation declaration, and
+// compute their defaults.
+func (r *resolver) resolveAnnotationDecl(s *symbol) {
+	if s.resolved {
+		return
+	}
+	s.resolved = true
+
+	decl := s.annotation
+	seen := make(map[string]int)
+	for i := range decl.Members {
+		m := &decl.Members[i]
+		if j, ok := seen[m.Name]; ok {
+			r.duplicate(m.Pos, decl.Members[j].Pos, "annotation @%s has more than one parameter %s", decl.Name, m.Name)
+		} else {
+			seen[m.Name] = i
+		}
+
+		var t *constType
+		if m.Type.Name == "any" {
+			t = &constType{name: "any", any: true}
+		} else if ct, ok := r.constType(m.Type, s.scope); ok {
+			t = &ct
+		}
+		s.paramTypes = append(s.paramTypes, t)
+
+		if t == nil || m.Default == nil {
+			continue
+		}
+		v, ok := r.eval(m.Default, s.scope, *t)
+		if ok {
+			v, ok = r.convert(v, *t, m.Default.Pos())
+		}
+		if ok {
+			m.DefaultValue = v
+		}
+	}
+}
+
+// Mark the members named by #pragma keylist directives with @key, as if they
+// had been written that way.
+func (r *resolver) applyKeylists() {
+	for _, kl := range r.p.keylists {
+		s := r.lookup(kl.typeName, kl.scope)
+		if s == nil {
+			r.errorf(CodeUnknownName, kl.pos, "unknown type in keylist: %s", kl.typeName)
+			continue
+		}
+		if s.strct == nil {
+			r.errorf(CodeTypeMismatch, kl.pos, "%s in keylist is not a struct", kl.typeName)
+			continue
+		}
+
+		for _, key := range kl.keys {
+			found := false
+			for i := range s.strct.Members {
+				m := &s.strct.Members[i]
+				if m.Name != key.Value {
+					continue
+				}
+				found = true
+				if !m.Annotations.Has("key") {
+					// Members declared together share their annotations,
+					// so copy them before adding to them.
+					m.Annotations = append(m.Annotations[:len(m.Annotations):len(m.Annotations)], Annotation{Name: "key", Pos: key.Pos})
+				}
+				break
+			}
+			if !found {
+				r.errorf(CodeUnknownName, key.Pos, "struct %s has no member %s", s.name, key.Value)
+			}
+		}
+	}
+}
+
+// Report an annotation that is not known, as the options ask.
+func (r *resolver) unknownAnnotation(a *Annotation) {
+	severity := SeverityWarning
+	switch r.p.opts.UnknownAnnotations {
+	case UnknownAnnotationIgnore:
+		r.p.debugf("Unknown annotation: @%s", a.Name)
+		return
+	case UnknownAnnotationError:
+		severity = SeverityError
+	}
+	r.p.report(&Diagnostic{
+		Code:     CodeUnknownAnnotation,
+		Severity: severity,
+		Pos:      a.Pos,
+		Msg:      "unknown annotation @" + a.Name,
+	})
+}
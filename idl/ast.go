@@ -5,29 +5,439 @@ import (
 	"strings"
 )
 
+// Comment is a single comment in the source.
+type Comment struct {
+	// The text of the comment, including the comment markers (// or /* */)
+	Text string
+
+	// Where the comment starts
+	Pos Position
+}
+
+// Comments holds the comments attached to a declaration.
+type Comments struct {
+	// The comments on the lines directly above the declaration, with no
+	// blank line in between.
+	Leading []Comment
+
+	// The comments following the declaration on the same line, e.g.
+	// "long foo; // the foo"
+	Trailing []Comment
+}
+
+// Text returns the text of the leading comments, with the comment markers
+// removed, as it might be used for documentation.
+func (c Comments) Text() string {
+	return commentText(c.Leading)
+}
+
+// TrailingText returns the text of the trailing comments, with the comment
+// markers removed.
+func (c Comments) TrailingText() string {
+	return commentText(c.Trailing)
+}
+
+// Join up comments, stripping the markers, and the "*" decoration that block
+// comments often have at the start of each line.
+func commentText(comments []Comment) string {
+	lines := []string{}
+	for _, c := range comments {
+		text := c.Text
+		if strings.HasPrefix(text, "//") {
+			text = strings.TrimRight(strings.TrimPrefix(text, "//"), " \t")
+			lines = append(lines, strings.TrimPrefix(text, " "))
+			continue
+		}
+
+		text = strings.TrimSuffix(strings.TrimPrefix(text, "/*"), "*/")
+		blockLines := strings.Split(text, "\n")
+		for i, line := range blockLines {
+			line = strings.TrimRight(line, " \t\r")
+			if i > 0 {
+				line = strings.TrimLeft(line, " \t")
+			}
+			line = strings.TrimLeft(line, "*")
+			blockLines[i] = strings.TrimPrefix(line, " ")
+		}
+
+		// Drop the empty lines left by "/*" and "*/" on lines of their own.
+		for len(blockLines) > 0 && blockLines[0] == "" {
+			blockLines = blockLines[1:]
+		}
+		for len(blockLines) > 0 && blockLines[len(blockLines)-1] == "" {
+			blockLines = blockLines[:len(blockLines)-1]
+		}
+		lines = append(lines, blockLines...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Annotation is an annotation applied to a declaration, e.g. @key, @id(5) or
+// @range(min = 0, max = 10).
+type Annotation struct {
+	// The name of the annotation, without the @
+	Name string
+
+	// Where the annotation is written, at the @
+	Pos Position
+
+	// The parameters given by position, as in @id(5)
+	Params []Expr
+
+	// The parameters given by name, as in @range(min = 0, max = 10), in the
+	// order they are written
+	NamedParams []AnnotationParam
+
+	// The declaration of the annotation, if it is declared with @annotation
+	Decl *AnnotationDecl
+
+	// The values of all the parameters of an annotation declared with
+	// @annotation, by name, including those left to their defaults
+	Values map[string]Value
+}
+
+// AnnotationParam is a parameter of an annotation given by name.
+type AnnotationParam struct {
+	// The name of the parameter (e.g. min)
+	Name string
+
+	// Where the parameter is written
+	Pos Position
+
+	// The value of the parameter, as written
+	Expr Expr
+}
+
+func (a Annotation) String() string {
+	params := []string{}
+	for _, e := range a.Params {
+		params = append(params, e.String())
+	}
+	for _, np := range a.NamedParams {
+		params = append(params, np.Name+" = "+np.Expr.String())
+	}
+	if len(params) == 0 {
+		return "@" + a.Name
+	}
+	return fmt.Sprintf("@%s(%s)", a.Name, strings.Join(params, ", "))
+}
+
+// Param returns the value of a parameter given by name. The only parameter
+// given by position is taken to be the one named "value", as in @id(5).
+// Returns nil if the parameter is not given.
+func (a Annotation) Param(name string) Expr {
+	for _, np := range a.NamedParams {
+		if np.Name == name {
+			return np.Expr
+		}
+	}
+	if name == "value" && len(a.Params) == 1 {
+		return a.Params[0]
+	}
+	return nil
+}
+
+// Annotations is the list of annotations applied to a declaration.
+type Annotations []Annotation
+
+// Get returns the annotation with the given name, if it is there.
+func (as Annotations) Get(name string) (Annotation, bool) {
+	for _, a := range as {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return Annotation{}, false
+}
+
+// Has says whether the annotation with the given name is there, e.g. @key.
+func (as Annotations) Has(name string) bool {
+	_, ok := as.Get(name)
+	return ok
+}
+
+// AnnotationDecl represents the declaration of an annotation in the AST, e.g.
+// @annotation MyAnno { long level default 1; string tag; };
+type AnnotationDecl struct {
+	// The name of the annotation (e.g. MyAnno)
+	Name string
+
+	// Where the annotation is declared
+	Pos Position
+
+	// The comments attached to the annotation
+	Doc Comments
+
+	// The parameters the annotation takes
+	Members []AnnotationMember
+}
+
+// AnnotationMember represents a parameter of an annotation declaration in
+// the AST, e.g. "long level default 1"
+type AnnotationMember struct {
+	// The name of the parameter (e.g. level)
+	Name string
+
+	// Where the parameter is declared
+	Pos Position
+
+	// The comments attached to the parameter
+	Doc Comments
+
+	// The type of the parameter (e.g. long). It may be "any".
+	Type Type
+
+	// The expression giving the default value of the parameter. If this is
+	// nil, the parameter must be given wherever the annotation is used.
+	Default Expr
+
+	// The default value, computed from Default
+	DefaultValue Value
+}
+
 // Type provides a parsed representation of an IDL type.
 type Type struct {
 	// The name of the type, e.g. "boolean", or "sequence" in "sequence<string>"
 	Name string
 
-	// The number of this type, used for fixed size arrays, e.g: int foo[3] --
-	// Quantity will be 3. If there is no quantity, this will be nil.
-	Quantity *int
+	// The dimensions of an array, outermost first, e.g. for octet data[4][2],
+	// these are 4 and 2. Empty if this is not an array.
+	Dimensions []Bound
 
 	// Any parameters of the type if the type is a templated one (e.g. "string"
-	// in "sequence<string>")
+	// in "sequence<string>", or the key and value types of a map)
 	TemplateParameters []Type
+
+	// The bound of a bounded sequence, map or string, e.g. 10 in string<10>
+	// or sequence<long, 10>, or the digits of a fixed point type, e.g. 10 in
+	// fixed<10, 2>. If there is no bound, this will be nil.
+	Bound *Bound
+
+	// The scale of a fixed point type, e.g. 2 in fixed<10, 2>. Otherwise,
+	// this will be nil.
+	Scale *Bound
+
+	// What sort of type this is
+	Kind TypeKind
+
+	// Which basic type this is, or PrimitiveNone if it is not one
+	Primitive PrimitiveKind
+
+	// Where the type is written in the source
+	Pos Position
 }
 
 func (t Type) String() string {
 	tparams := []string{}
 	for _, tp := range t.TemplateParameters {
-		tparams = append(tparams, tp.Name)
+		tparams = append(tparams, tp.String())
+	}
+	if t.Bound != nil {
+		tparams = append(tparams, t.Bound.Expr.String())
+	}
+	if t.Scale != nil {
+		tparams = append(tparams, t.Scale.Expr.String())
 	}
+
+	s := t.Name
 	if len(tparams) > 0 {
-		return fmt.Sprintf("%s<%s>", t.Name, strings.Join(tparams, ", "))
+		s = fmt.Sprintf("%s<%s>", t.Name, strings.Join(tparams, ", "))
+	}
+	for _, dim := range t.Dimensions {
+		s += "[" + dim.Expr.String() + "]"
+	}
+	return s
+}
+
+// StringBound returns the bound of a string or wstring type, e.g. 10 for
+// string<10>, or 0 if it is unbounded. ok is false if t is not a string type.
+func (t Type) StringBound() (bound int, ok bool) {
+	if t.Primitive != PrimitiveString && t.Primitive != PrimitiveWString {
+		return 0, false
+	}
+	if t.Bound != nil {
+		bound = t.Bound.Value
+	}
+	return bound, true
+}
+
+// SequenceBound returns the bound of a sequence type, e.g. 10 for
+// sequence<long, 10>, or 0 if it is unbounded. ok is false if t is not a
+// sequence.
+func (t Type) SequenceBound() (bound int, ok bool) {
+	if t.Kind != TypeSequence {
+		return 0, false
+	}
+	if t.Bound != nil {
+		bound = t.Bound.Value
+	}
+	return bound, true
+}
+
+// MapTypes returns the key and value types of a map type, e.g. string and
+// long for map<string, long>. ok is false if t is not a map.
+func (t Type) MapTypes() (key Type, value Type, ok bool) {
+	if t.Kind != TypeMap {
+		return Type{}, Type{}, false
+	}
+	return t.TemplateParameters[0], t.TemplateParameters[1], true
+}
+
+// MapBound returns the bound of a map type, e.g. 32 for map<string, long, 32>,
+// or 0 if it is unbounded. ok is false if t is not a map.
+func (t Type) MapBound() (bound int, ok bool) {
+	if t.Kind != TypeMap {
+		return 0, false
+	}
+	if t.Bound != nil {
+		bound = t.Bound.Value
+	}
+	return bound, true
+}
+
+// TypeKind says what sort of type a Type is.
+type TypeKind int
+
+const (
+	// TypeNamed is a type referred to by its name, such as a struct, an
+	// enum or a typedef.
+	TypeNamed TypeKind = iota
+
+	// TypePrimitive is one of the basic types, given by Type.Primitive.
+	TypePrimitive
+
+	// TypeSequence is sequence<T> or sequence<T, N>. The element type is
+	// the only template parameter.
+	TypeSequence
+
+	// TypeMap is map<K, V> or map<K, V, N>. The key and value types are
+	// the template parameters.
+	TypeMap
+)
+
+func (k TypeKind) String() string {
+	switch k {
+	case TypeNamed:
+		return "named"
+	case TypePrimitive:
+		return "primitive"
+	case TypeSequence:
+		return "sequence"
+	case TypeMap:
+		return "map"
+	}
+	return fmt.Sprintf("TypeKind(%d)", int(k))
+}
+
+// FixedDigits returns the total digits and the digits after the decimal point
+// of a fixed point type, e.g. 10 and 2 for fixed<10, 2>. ok is false if t is
+// not a fixed point type with both given.
+func (t Type) FixedDigits() (digits int, scale int, ok bool) {
+	if t.Primitive != PrimitiveFixed || t.Bound == nil || t.Scale == nil {
+		return 0, 0, false
+	}
+	return t.Bound.Value, t.Scale.Value, true
+}
+
+// PrimitiveKind says which of IDL's basic types a Type is.
+type PrimitiveKind int
+
+const (
+	// PrimitiveNone is a type that is not a basic type, such as a struct,
+	// a typedef or a sequence.
+	PrimitiveNone PrimitiveKind = iota
+
+	PrimitiveVoid
+	PrimitiveBoolean
+	PrimitiveChar
+	PrimitiveWChar
+	PrimitiveOctet
+	PrimitiveShort
+	PrimitiveLong
+	PrimitiveLongLong
+	PrimitiveUnsignedShort
+	PrimitiveUnsignedLong
+	PrimitiveUnsignedLongLong
+	PrimitiveInt8
+	PrimitiveInt16
+	PrimitiveInt32
+	PrimitiveInt64
+	PrimitiveUInt8
+	PrimitiveUInt16
+	PrimitiveUInt32
+	PrimitiveUInt64
+	PrimitiveFloat
+	PrimitiveDouble
+	PrimitiveLongDouble
+	PrimitiveString
+	PrimitiveWString
+	PrimitiveFixed
+	PrimitiveAny
+	PrimitiveObject
+	PrimitiveValueBase
+)
+
+// The names of the basic types, as written in IDL.
+var primitiveNames = map[PrimitiveKind]string{
+	PrimitiveVoid:             "void",
+	PrimitiveBoolean:          "boolean",
+	PrimitiveChar:             "char",
+	PrimitiveWChar:            "wchar",
+	PrimitiveOctet:            "octet",
+	PrimitiveShort:            "short",
+	PrimitiveLong:             "long",
+	PrimitiveLongLong:         "long long",
+	PrimitiveUnsignedShort:    "unsigned short",
+	PrimitiveUnsignedLong:     "unsigned long",
+	PrimitiveUnsignedLongLong: "unsigned long long",
+	PrimitiveInt8:             "int8",
+	PrimitiveInt16:            "int16",
+	PrimitiveInt32:            "int32",
+	PrimitiveInt64:            "int64",
+	PrimitiveUInt8:            "uint8",
+	PrimitiveUInt16:           "uint16",
+	PrimitiveUInt32:           "uint32",
+	PrimitiveUInt64:           "uint64",
+	PrimitiveFloat:            "float",
+	PrimitiveDouble:           "double",
+	PrimitiveLongDouble:       "long double",
+	PrimitiveString:           "string",
+	PrimitiveWString:          "wstring",
+	PrimitiveFixed:            "fixed",
+	PrimitiveAny:              "any",
+	PrimitiveObject:           "Object",
+	PrimitiveValueBase:        "ValueBase",
+}
+
+// The basic types, by name.
+var primitiveKinds = map[string]PrimitiveKind{}
+
+func init() {
+	for k, name := range primitiveNames {
+		primitiveKinds[name] = k
+	}
+}
+
+func (k PrimitiveKind) String() string {
+	if name, ok := primitiveNames[k]; ok {
+		return name
 	}
-	return fmt.Sprintf("%s", t.Name)
+	return fmt.Sprintf("PrimitiveKind(%d)", int(k))
+}
+
+// IsInteger says whether k is one of the integer types, including octet.
+func (k PrimitiveKind) IsInteger() bool {
+	return k >= PrimitiveOctet && k <= PrimitiveUInt64
+}
+
+// Bound is a size given by a constant expression, such as the dimension of an
+// array, or the bound of a sequence.
+type Bound struct {
+	// The expression giving the size, as written
+	Expr Expr
+
+	// The size, computed from Expr. This is 0 if it could not be computed.
+	Value int
 }
 
 // Union provides a representation of a union in the AST
@@ -35,23 +445,60 @@ type Union struct {
 	// The name of the union
 	Name string
 
+	// Where the union is declared
+	Pos Position
+
+	// The comments attached to the union
+	Doc Comments
+
+	// The annotations applied to the union (e.g. @appendable)
+	Annotations Annotations
+
 	// The type the union operates on
 	Discriminant Type
 
 	// The cases of the union
 	Members []UnionMember
+
+	// The types and constants declared inside this union
+	Declarations
 }
 
 // UnionMember represents a member in a Union
 type UnionMember struct {
-	// The discrimnant value for this member
-	CaseValue Type
+	// The values of the discriminant that select this member, e.g. RED and
+	// GREEN in "case RED: case GREEN:"
+	Labels []CaseLabel
+
+	// Whether this member is selected by any value not given by another
+	// member's labels ("default:")
+	IsDefault bool
 
 	// The type of the value returned
 	MemberType Type
 
 	// The name of the value returned
 	MemberName string
+
+	// Where the member is declared
+	Pos Position
+
+	// The comments attached to the member
+	Doc Comments
+
+	// The annotations applied to the member (e.g. @key, @optional)
+	Annotations Annotations
+}
+
+// CaseLabel is a value of a union's discriminant that selects a member, e.g.
+// the 1 in "case 1:"
+type CaseLabel struct {
+	// The expression giving the label, as written
+	Expr Expr
+
+	// The value of the label, converted to the discriminant's type. If it
+	// could not be computed, this is the zero Value.
+	Value Value
 }
 
 // Member provides a generic representation of a member in the AST
@@ -59,6 +506,15 @@ type Member struct {
 	// The name of the member
 	Name string
 
+	// Where the member is declared
+	Pos Position
+
+	// The comments attached to the member
+	Doc Comments
+
+	// The annotations applied to the member (e.g. @key, @optional)
+	Annotations Annotations
+
 	// The type of the member (e.g. "unsigned long")
 	Type Type
 }
@@ -71,8 +527,13 @@ type Constant struct {
 	// The meta-information about the constant
 	Member
 
-	// The value of the constant
-	Value string
+	// The expression giving the value of the constant, as written (e.g.
+	// "0x0001 << 2")
+	Expr Expr
+
+	// The value of the constant, computed from Expr and converted to the
+	// constant's type. If it could not be computed, this is the zero Value.
+	Value Value
 }
 
 // Struct represents a struct in the AST
@@ -80,11 +541,23 @@ type Struct struct {
 	// The name of the struct
 	Name string
 
+	// Where the struct is declared
+	Pos Position
+
+	// The comments attached to the struct
+	Doc Comments
+
+	// The annotations applied to the struct (e.g. @topic)
+	Annotations Annotations
+
 	// What struct this struct inherits
 	Inherits []string
 
 	// The members inside this struct
 	Members []Member
+
+	// The types and constants declared inside this struct
+	Declarations
 }
 
 // Enum represents an enum in the AST
@@ -92,21 +565,200 @@ type Enum struct {
 	// The name of the enum
 	Name string
 
+	// Where the enum is declared
+	Pos Position
+
+	// The comments attached to the enum
+	Doc Comments
+
+	// The annotations applied to the enum (e.g. @bit_bound(8))
+	Annotations Annotations
+
+	// The number of bits that hold the enum's values, from @bit_bound. If
+	// this is nil, the values are 32 bit.
+	BitBound *Bound
+
 	// The members inside this enum
-	Members []Member
+	Members []Enumerator
+}
+
+// Bitmask represents a bitmask in the AST, e.g. bitmask Perms { READ, WRITE };
+type Bitmask struct {
+	// The name of the bitmask
+	Name string
+
+	// Where the bitmask is declared
+	Pos Position
+
+	// The comments attached to the bitmask
+	Doc Comments
+
+	// The annotations applied to the bitmask (e.g. @bit_bound(16))
+	Annotations Annotations
+
+	// The number of bits in the bitmask, from @bit_bound. If this is nil,
+	// the bitmask has 32 bits.
+	BitBound *Bound
+
+	// The flags inside this bitmask
+	Members []BitValue
+}
+
+// BitValue represents a flag in a bitmask in the AST
+type BitValue struct {
+	// The name of the flag
+	Name string
+
+	// Where the flag is declared
+	Pos Position
+
+	// The comments attached to the flag
+	Doc Comments
+
+	// The annotations applied to the flag (e.g. @position(3))
+	Annotations Annotations
+
+	// The expression giving the bit of the flag, from @position(3). If the
+	// position is implicit, this is nil.
+	Expr Expr
+
+	// The bit of the flag, counting from 0. Implicit positions follow on
+	// from the one before, starting at 0.
+	Position int
+}
+
+// Bitset represents a bitset in the AST, e.g.
+// bitset Flags { bitfield<3> a; bitfield<5, octet> b; };
+type Bitset struct {
+	// The name of the bitset
+	Name string
+
+	// Where the bitset is declared
+	Pos Position
+
+	// The comments attached to the bitset
+	Doc Comments
+
+	// The annotations applied to the bitset
+	Annotations Annotations
+
+	// The bitset this one extends, if any (bitset Foo : Bar)
+	Inherits string
+
+	// The bitset named by Inherits. This is filled in once the whole file is
+	// parsed, and left nil if the bitset cannot be found or extends itself.
+	Base *Bitset
+
+	// The fields inside this bitset, in order
+	Fields []BitField
+
+	// The number of bits in the bitset, including those of the bitset it
+	// extends
+	Bits int
+}
+
+// BitField represents a field of a bitset in the AST
+type BitField struct {
+	// The name of the field. It is empty for unused bits: bitfield<3>;
+	Name string
+
+	// Where the field is declared
+	Pos Position
+
+	// The comments attached to the field
+	Doc Comments
+
+	// The annotations applied to the field
+	Annotations Annotations
+
+	// The number of bits in the field, e.g. 3 in bitfield<3>
+	Width Bound
+
+	// The type that holds the field's value, e.g. octet in
+	// bitfield<5, octet>. If it is not given, it is the smallest of
+	// boolean, octet, unsigned short, unsigned long and unsigned long long
+	// that fits the width.
+	Type Type
+
+	// Where the field starts in the bitset, counting bits from 0, after the
+	// fields before it and those of the bitset it extends
+	Offset int
 }
 
-// MethodParameter is a specialization of Type to provide the direction of the
-// type.
+// Enumerator represents a member of an enum in the AST
+type Enumerator struct {
+	// The name of the enumerator
+	Name string
+
+	// Where the enumerator is declared
+	Pos Position
+
+	// The comments attached to the enumerator
+	Doc Comments
+
+	// The annotations applied to the enumerator (e.g. @value(5))
+	Annotations Annotations
+
+	// The expression giving the value of the enumerator, from @value(5) or
+	// "= 5". If the value is implicit, this is nil.
+	Expr Expr
+
+	// The value of the enumerator. Implicit values follow on from the one
+	// before, starting at 0.
+	Value int
+}
+
+// Direction says which way a method parameter is passed.
+type Direction int
+
+const (
+	// DirectionIn is a parameter passed to the method: in long foo
+	DirectionIn Direction = iota
+
+	// DirectionOut is a parameter passed back by the method: out long foo
+	DirectionOut
+
+	// DirectionInOut is a parameter passed both ways: inout long foo
+	DirectionInOut
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionIn:
+		return keywordIn
+	case DirectionOut:
+		return keywordOut
+	case DirectionInOut:
+		return keywordInOut
+	}
+	return fmt.Sprintf("Direction(%d)", int(d))
+}
+
+// MethodParameter represents a parameter of a Method in the AST
+// For instance, "inout sequence<long> foo"
 type MethodParameter struct {
-	Type
+	// The name of the parameter (e.g. foo). It may be empty, as the name
+	// can be left out.
+	Name string
+
+	// Where the parameter is declared
+	Pos Position
+
+	// The type of the parameter (e.g. sequence<long>)
+	Type Type
+
+	// Which way the parameter is passed (e.g. DirectionInOut)
+	Direction Direction
 
-	// in, out, inout
-	Direction string
+	// The annotations applied to the parameter
+	Annotations Annotations
 }
 
 func (t MethodParameter) String() string {
-	return fmt.Sprintf("%s %s", t.Direction, t.Type)
+	if t.Name == "" {
+		return fmt.Sprintf("%s %s", t.Direction, t.Type)
+	}
+	return fmt.Sprintf("%s %s %s", t.Direction, t.Type, t.Name)
 }
 
 // Method represents the contents of a method in an Interface in the AST
@@ -115,11 +767,77 @@ type Method struct {
 	// The name of the method (e.g. foo)
 	Name string
 
+	// Where the method is declared
+	Pos Position
+
+	// The comments attached to the method
+	Doc Comments
+
 	// The return value of the method (e.g. void)
 	ReturnValue Type
 
 	// The parameters of the method (e.g. "inout int foo")
 	Parameters []MethodParameter
+
+	// Whether the method is oneway, so the caller does not wait for it to
+	// finish (e.g. "oneway void ping()")
+	Oneway bool
+
+	// The exceptions the method may raise, from "raises (NotFound)"
+	Raises []string
+
+	// The context names passed to the method, from "context ("x")"
+	Context []string
+
+	// The annotations applied to the method (e.g. @oneway)
+	Annotations Annotations
+}
+
+// Attribute represents an attribute of an Interface in the AST
+// For instance, "readonly attribute long count"
+type Attribute struct {
+	// The name of the attribute (e.g. count)
+	Name string
+
+	// Where the attribute is declared
+	Pos Position
+
+	// The comments attached to the attribute
+	Doc Comments
+
+	// The type of the attribute (e.g. long)
+	Type Type
+
+	// Whether the attribute can only be read
+	Readonly bool
+
+	// The exceptions reading the attribute may raise, from "getraises (Err)",
+	// or "raises (Err)" on a readonly attribute
+	GetRaises []string
+
+	// The exceptions writing the attribute may raise, from "setraises (Err)"
+	SetRaises []string
+
+	// The annotations applied to the attribute
+	Annotations Annotations
+}
+
+// Exception represents an exception in the AST
+type Exception struct {
+	// The name of the exception
+	Name string
+
+	// Where the exception is declared
+	Pos Position
+
+	// The comments attached to the exception
+	Doc Comments
+
+	// The annotations applied to the exception
+	Annotations Annotations
+
+	// The members inside this exception
+	Members []Member
 }
 
 // Interface represents an interface in the AST
@@ -127,11 +845,74 @@ type Interface struct {
 	// The name of the interface
 	Name string
 
+	// Where the interface is declared
+	Pos Position
+
+	// The comments attached to the interface
+	Doc Comments
+
+	// The annotations applied to the interface
+	Annotations Annotations
+
 	// What interfaces this interface inherits
 	Inherits []string
 
 	// What methods this interface provides
 	Methods []Method
+
+	// What attributes this interface provides
+	Attributes []Attribute
+
+	// The types and constants declared inside this interface
+	Declarations
+}
+
+// ForwardKind says what a forward declaration declares.
+type ForwardKind int
+
+const (
+	// ForwardStruct is a forward declared struct: struct Foo;
+	ForwardStruct ForwardKind = iota
+
+	// ForwardUnion is a forward declared union: union Foo;
+	ForwardUnion
+
+	// ForwardInterface is a forward declared interface: interface Foo;
+	ForwardInterface
+)
+
+func (k ForwardKind) String() string {
+	switch k {
+	case ForwardStruct:
+		return keywordStruct
+	case ForwardUnion:
+		return keywordUnion
+	case ForwardInterface:
+		return keywordInterface
+	}
+	return fmt.Sprintf("ForwardKind(%d)", int(k))
+}
+
+// ForwardDecl represents a forward declaration in the AST, e.g. "struct Foo;".
+// It allows Foo to be used before it is defined, as in recursive types.
+type ForwardDecl struct {
+	// What is declared
+	Kind ForwardKind
+
+	// The name of the declared type
+	Name string
+
+	// Where the forward declaration is
+	Pos Position
+
+	// The comments attached to the forward declaration
+	Doc Comments
+
+	// The full definition of the type, according to Kind. These are filled
+	// in once the whole file is parsed.
+	Struct    *Struct
+	Union     *Union
+	Interface *Interface
 }
 
 // Module is the base type of the AST generated from the parsed IDL.
@@ -144,27 +925,59 @@ type Module struct {
 	// The name of the module
 	Name string
 
+	// Where the module is declared. For the root module, this is the start of
+	// the file.
+	Pos Position
+
+	// The comments attached to the module
+	Doc Comments
+
+	// The annotations applied to the module (e.g. @default_nested)
+	Annotations Annotations
+
 	// The parent module
 	Parent *Module
 
 	// Modules inside this module
 	Modules []Module
 
-	// Unions inside this module
-	Unions []Union
-
 	// Interfaces inside this module
 	Interfaces []Interface
 
-	// All typedefs in this module
+	// The types and constants declared in this module
+	Declarations
+}
+
+// Declarations holds the types and constants declared in a module, or nested
+// inside an interface, struct or union (as in interface Foo { enum Bar {...}; }).
+type Declarations struct {
+	// Unions declared in the scope
+	Unions []Union
+
+	// All typedefs declared in the scope
 	TypeDefs []TypeDef
 
-	// All structs in this module
+	// All structs declared in the scope
 	Structs []Struct
 
-	// All constants in this module
+	// All constants declared in the scope
 	Constants []Constant
 
-	// All enums in this module
+	// All enums declared in the scope
 	Enums []Enum
+
+	// Forward declarations in the scope
+	ForwardDecls []ForwardDecl
+
+	// All exceptions declared in the scope
+	Exceptions []Exception
+
+	// All bitmasks declared in the scope
+	Bitmasks []Bitmask
+
+	// All bitsets declared in the scope
+	Bitsets []Bitset
+
+	// All annotations declared in the scope with @annotation
+	AnnotationDecls []AnnotationDecl
 }
@@ -0,0 +1,308 @@
+package idl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Severity describes how serious a Diagnostic is.
+type Severity int
+
+const (
+	// SeverityError is a problem that makes the IDL invalid.
+	SeverityError Severity = iota
+
+	// SeverityWarning is a likely problem that does not stop processing.
+	SeverityWarning
+
+	// SeverityNote is additional information, usually attached to another
+	// diagnostic.
+	SeverityNote
+)
+
+// Turn a Severity into a string.
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityNote:
+		return "note"
+	}
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+// A DiagnosticCode identifies a kind of problem. Codes are stable, so that
+// tools can match on them rather than on message text.
+type DiagnosticCode string
+
+// Lexical problems.
+const (
+	// CodeUnterminatedString is a string literal missing its closing quote.
+	CodeUnterminatedString DiagnosticCode = "IDL1001"
+
+	// CodeInvalidCharacter is a character that cannot start any token.
+	CodeInvalidCharacter DiagnosticCode = "IDL1002"
+
+	// CodeUnterminatedComment is a block comment missing its closing */.
+	CodeUnterminatedComment DiagnosticCode = "IDL1003"
+
+	// CodeInvalidNumber is a malformed numeric literal, such as 09 or 1.2.3.
+	CodeInvalidNumber DiagnosticCode = "IDL1004"
+
+	// CodeInvalidEscape is a malformed escape sequence in a literal.
+	CodeInvalidEscape DiagnosticCode = "IDL1005"
+)
+
+// Syntax problems.
+const (
+	// CodeUnexpectedToken is a token other than the one the grammar requires.
+	CodeUnexpectedToken DiagnosticCode = "IDL2001"
+
+	// CodeUnexpectedEOF is a file ending in the middle of a declaration.
+	CodeUnexpectedEOF DiagnosticCode = "IDL2002"
+
+	// CodeUnexpectedKeyword is a word that cannot start a declaration.
+	CodeUnexpectedKeyword DiagnosticCode = "IDL2003"
+
+	// CodeUnterminatedScope is a module, struct, etc. that is never closed.
+	CodeUnterminatedScope DiagnosticCode = "IDL2004"
+
+	// CodeUnknownDirective is an unsupported # directive.
+	CodeUnknownDirective DiagnosticCode = "IDL2005"
+
+	// CodeInvalidArraySize is an array size or bound that is not a positive
+	// integer.
+	CodeInvalidArraySize DiagnosticCode = "IDL2006"
+
+	// CodeMacroRedefined is a #define of a macro that is already defined
+	// differently.
+	CodeMacroRedefined DiagnosticCode = "IDL2007"
+
+	// CodeMacroArguments is a function-like macro used with the wrong number
+	// of arguments, or without its closing bracket.
+	CodeMacroArguments DiagnosticCode = "IDL2008"
+
+	// CodeUnbalancedConditional is an #if without an #endif, or an #else,
+	// #elif or #endif without an #if.
+	CodeUnbalancedConditional DiagnosticCode = "IDL2009"
+
+	// CodeErrorDirective is an #error or #warning directive that was not
+	// skipped.
+	CodeErrorDirective DiagnosticCode = "IDL2010"
+
+	// CodeIncludeNotFound is an #include of a file that cannot be read.
+	CodeIncludeNotFound DiagnosticCode = "IDL2011"
+
+	// CodeIncludeCycle is a file that includes itself, directly or through
+	// other files.
+	CodeIncludeCycle DiagnosticCode = "IDL2012"
+
+	// CodeDanglingAnnotation is an annotation that is not followed by a
+	// declaration for it to apply to.
+	CodeDanglingAnnotation DiagnosticCode = "IDL2013"
+
+	// CodeIncludeNotLoaded is an #include that is not loaded, because
+	// ParseOptions.FS is not set.
+	CodeIncludeNotLoaded DiagnosticCode = "IDL2014"
+)
+
+// Semantic problems.
+const (
+	// CodeUnknownName is a reference to a name that is not declared.
+	CodeUnknownName DiagnosticCode = "IDL3001"
+
+	// CodeTypeMismatch is a value of the wrong type, e.g. a string given
+	// for a long constant, or an operator applied to a boolean.
+	CodeTypeMismatch DiagnosticCode = "IDL3002"
+
+	// CodeOutOfRange is a value that does not fit its type.
+	CodeOutOfRange DiagnosticCode = "IDL3003"
+
+	// CodeDivisionByZero is a division or remainder by zero.
+	CodeDivisionByZero DiagnosticCode = "IDL3004"
+
+	// CodeRecursiveDefinition is a constant whose value depends on itself.
+	CodeRecursiveDefinition DiagnosticCode = "IDL3005"
+
+	// CodeDuplicateValue is a value that must be unique but is not, such as
+	// two enumerators with the same value.
+	CodeDuplicateValue DiagnosticCode = "IDL3006"
+
+	// CodeUndefinedType is a forward declared type that is never defined.
+	CodeUndefinedType DiagnosticCode = "IDL3007"
+
+	// CodeUnknownAnnotation is an annotation that is neither built in nor
+	// declared with @annotation.
+	CodeUnknownAnnotation DiagnosticCode = "IDL3008"
+
+	// CodeMissingParameter is an annotation used without a parameter that
+	// has no default.
+	CodeMissingParameter DiagnosticCode = "IDL3009"
+
+	// CodeRedefinition is a name declared more than once in the same scope.
+	CodeRedefinition DiagnosticCode = "IDL3010"
+)
+
+// RelatedInformation points at another location that helps to explain a
+// Diagnostic, such as where a block was opened.
+type RelatedInformation struct {
+	// Where the related location starts
+	Pos Position
+
+	// Where the related location ends (exclusive). May be invalid.
+	End Position
+
+	// A description of the location
+	Msg string
+}
+
+// Diagnostic describes a problem found in an IDL file.
+type Diagnostic struct {
+	// A stable identifier for the kind of problem
+	Code DiagnosticCode
+
+	// How serious the problem is
+	Severity Severity
+
+	// Where the problem starts
+	Pos Position
+
+	// Where the problem ends (exclusive). If invalid, the problem is
+	// considered to cover a single character at Pos.
+	End Position
+
+	// A description of the problem
+	Msg string
+
+	// Other locations involved in the problem
+	Related []RelatedInformation
+}
+
+func (d *Diagnostic) Error() string {
+	return fmt.Sprintf("%s: %s", d.Pos, d.Msg)
+}
+
+// Render writes the diagnostic in the style of the Go compiler, followed by
+// the offending line of its file with the problem underlined:
+//
+//	foo.idl:3:10: error: expected semicolon [IDL2001]
+//	    long x }
+//	           ^
+//
+// source returns the content of a file, given its name as in
+// Position.Filename, or nil if it is not available. Diagnostics may be in
+// included files, so it is a lookup rather than the content of one file. If
+// source is nil, only the message is written. Related information is written
+// afterwards as notes, with their source lines.
+func (d *Diagnostic) Render(w io.Writer, source func(filename string) []byte) error {
+	_, err := fmt.Fprintf(w, "%s: %s: %s [%s]\n", d.Pos, d.Severity, d.Msg, d.Code)
+	if err != nil {
+		return err
+	}
+	if err = renderSourceLine(w, source, d.Pos, d.End); err != nil {
+		return err
+	}
+
+	for _, r := range d.Related {
+		if _, err = fmt.Fprintf(w, "%s: %s: %s\n", r.Pos, SeverityNote, r.Msg); err != nil {
+			return err
+		}
+		if err = renderSourceLine(w, source, r.Pos, r.End); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write the line of the source of pos's file containing pos, and a line
+// underlining pos to end.
+func renderSourceLine(w io.Writer, source func(filename string) []byte, pos Position, end Position) error {
+	if source == nil || !pos.IsValid() {
+		return nil
+	}
+	src := source(pos.Filename)
+	if src == nil || pos.Offset > len(src) {
+		return nil
+	}
+
+	lineStart := pos.Offset - (pos.Column - 1)
+	if lineStart < 0 {
+		return nil
+	}
+	lineEnd := bytes.IndexByte(src[lineStart:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(src)
+	} else {
+		lineEnd += lineStart
+	}
+	line := bytes.TrimRight(src[lineStart:lineEnd], "\r")
+
+	// Keep tabs in the padding, so the caret lines up however wide the
+	// terminal displays them.
+	underline := []byte{}
+	for _, c := range line[:pos.Column-1] {
+		if c == '\t' {
+			underline = append(underline, '\t')
+		} else {
+			underline = append(underline, ' ')
+		}
+	}
+	underline = append(underline, '^')
+
+	// Underline the rest of the range, as far as the end of the line.
+	width := 1
+	if end.IsValid() && end.Offset > pos.Offset {
+		width = end.Offset - pos.Offset
+	}
+	if pos.Column-1+width > len(line) {
+		width = len(line) - (pos.Column - 1)
+	}
+	for i := 1; i < width; i++ {
+		underline = append(underline, '~')
+	}
+
+	_, err := fmt.Fprintf(w, "%s\n%s\n", line, underline)
+	return err
+}
+
+// ErrorList is a list of errors, in the order they were found. Lex and Parse
+// return an ErrorList as their error value, so that every problem in a file
+// can be reported at once rather than one by one.
+type ErrorList []*Diagnostic
+
+// Add a diagnostic to the list.
+func (l *ErrorList) Add(d *Diagnostic) {
+	*l = append(*l, d)
+}
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+// Err returns nil if the list is empty, or the list itself otherwise. This is
+// useful to avoid returning a non-nil error interface holding an empty list.
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+// Render writes every diagnostic in the list, as Diagnostic.Render does.
+func (l ErrorList) Render(w io.Writer, source func(filename string) []byte) error {
+	for _, d := range l {
+		if err := d.Render(w, source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
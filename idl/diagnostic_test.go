@@ -0,0 +1,72 @@
+package idl
+
+import (
+	"bytes"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRender(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.idl":  {Data: []byte(`#include "types.idl"` + "\nstruct M {\n\tlong m };\n")},
+		"types.idl": {Data: []byte("module T {\n  struct S { long x y; };\n};\n")},
+	}
+	source := func(name string) []byte {
+		if f, ok := fsys[name]; ok {
+			return f.Data
+		}
+		return nil
+	}
+	opts := ParseOptions{FS: fsys}
+	toks, _ := LexWithOptions("main.idl", fsys["main.idl"].Data, opts)
+	_, err := ParseWithOptions(toks, opts)
+
+	var buf bytes.Buffer
+	if err := diagnostics(t, err).Render(&buf, source); err != nil {
+		t.Fatal(err)
+	}
+	want := `types.idl:2:21: error: expected semicolon [IDL2001]
+  struct S { long x y; };
+                    ^
+main.idl:3:9: error: expected semicolon [IDL2001]
+	long m };
+	       ^
+`
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	// Without sources, only the messages are written.
+	buf.Reset()
+	diagnostics(t, err).Render(&buf, nil)
+	want = `types.idl:2:21: error: expected semicolon [IDL2001]
+main.idl:3:9: error: expected semicolon [IDL2001]
+`
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestRenderRelated(t *testing.T) {
+	src := []byte("struct S {\n\tlong x;\n")
+	toks, _ := LexFile("a.idl", src)
+	_, err := Parse(toks)
+
+	var buf bytes.Buffer
+	diagnostics(t, err).Render(&buf, func(name string) []byte {
+		if name == "a.idl" {
+			return src
+		}
+		return nil
+	})
+	want := `a.idl:2:9: error: unexpected EOF: unterminated struct S [IDL2004]
+	long x;
+	       ^
+a.idl:1:8: note: struct S opened here
+struct S {
+       ^
+`
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
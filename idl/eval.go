@@ -0,0 +1,322 @@
+package idl
+
+import (
+	"math"
+	"math/big"
+	"strings"
+	"unicode/utf8"
+)
+
+// The most digits a fixed point number can have.
+const maxFixedDigits = 31
+
+// Compute the value of an expression used in the given scope, for a constant
+// of type t. The result is not yet converted to t (see convert). Returns false
+// if it cannot be computed; the problem has been reported by then.
+func (r *resolver) eval(e Expr, scope string, t constType) (Value, bool) {
+	switch e := e.(type) {
+	case *LiteralExpr:
+		return e.Value, true
+	case *ParenExpr:
+		return r.eval(e.X, scope, t)
+	case *NameExpr:
+		return r.evalName(e, scope, t)
+	case *UnaryExpr:
+		return r.evalUnary(e, scope, t)
+	case *BinaryExpr:
+		return r.evalBinary(e, scope, t)
+	}
+	return Value{}, false
+}
+
+// Find the value of a constant or enumerator referred to by name.
+func (r *resolver) evalName(e *NameExpr, scope string, t constType) (Value, bool) {
+	s := r.lookup(e.Name, scope)
+	if s == nil {
+		r.errorf(CodeUnknownName, e.NamePos, "unknown name %s", e.Name)
+		return Value{}, false
+	}
+
+	switch s.kind {
+	case symbolConstant:
+		if s.resolving {
+			r.p.report(&Diagnostic{
+				Code:     CodeRecursiveDefinition,
+				Severity: SeverityError,
+				Pos:      e.NamePos,
+				Msg:      "constant " + s.name + " is defined in terms of itself",
+				Related: []RelatedInformation{{
+					Pos: s.pos,
+					Msg: s.name + " declared here",
+				}},
+			})
+			return Value{}, false
+		}
+		if !r.resolveConstant(s) {
+			return Value{}, false
+		}
+		return s.constant.Value, true
+	case symbolEnumerator:
+		if s.parent.resolving && s.index >= s.parent.next {
+			r.errorf(CodeRecursiveDefinition, e.NamePos, "enumerator %s is used before its value is known", s.name)
+			return Value{}, false
+		}
+		r.resolveEnum(s.parent)
+		return Value{Kind: ValueEnumerator, Str: s.name, Int: big.NewInt(int64(s.enum.Members[s.index].Value))}, true
+	}
+
+	r.errorf(CodeTypeMismatch, e.NamePos, "%s %s is not a constant", s.kind, e.Name)
+	return Value{}, false
+}
+
+func (r *resolver) evalUnary(e *UnaryExpr, scope string, t constType) (Value, bool) {
+	x, ok := r.eval(e.X, scope, t)
+	if !ok {
+		return Value{}, false
+	}
+
+	switch e.Op {
+	case OpAdd:
+		if x.Kind == ValueInteger || x.Kind == ValueFloat || x.Kind == ValueFixed {
+			return x, true
+		}
+	case OpSub:
+		if v, err := x.Negate(); err == nil {
+			return r.checkInteger(v, e.OpPos)
+		}
+	case OpNot:
+		if x.Kind == ValueInteger {
+			// The complement of an unsigned value stays within its type:
+			// ~0 is 0xffffffff for an unsigned long.
+			if t.kind == ValueInteger && t.min.Sign() == 0 {
+				if x.Int.Sign() < 0 || x.Int.Cmp(t.max) > 0 {
+					r.errorf(CodeOutOfRange, e.X.Pos(), "value %s out of range for %s", x, t.name)
+					return Value{}, false
+				}
+				x.Int = new(big.Int).Xor(x.Int, t.max)
+				return x, true
+			}
+			x.Int = new(big.Int).Not(x.Int)
+			return r.checkInteger(x, e.OpPos)
+		}
+	}
+
+	r.errorf(CodeTypeMismatch, e.OpPos, "operator %s not defined on %s values", e.Op, x.Kind)
+	return Value{}, false
+}
+
+func (r *resolver) evalBinary(e *BinaryExpr, scope string, t constType) (Value, bool) {
+	x, ok := r.eval(e.X, scope, t)
+	if !ok {
+		return Value{}, false
+	}
+	y, ok := r.eval(e.Y, scope, t)
+	if !ok {
+		return Value{}, false
+	}
+
+	// Integers mix with floating and fixed point numbers, as in 2.5 * 2.
+	if x.Kind != y.Kind {
+		if x.Kind == ValueInteger {
+			x = promote(x, y.Kind)
+		} else if y.Kind == ValueInteger {
+			y = promote(y, x.Kind)
+		}
+	}
+	if x.Kind != y.Kind {
+		r.errorf(CodeTypeMismatch, e.OpPos, "mismatched types %s and %s for operator %s", x.Kind, y.Kind, e.Op)
+		return Value{}, false
+	}
+
+	if (e.Op == OpDiv || e.Op == OpMod) && isZero(y) {
+		r.errorf(CodeDivisionByZero, e.OpPos, "division by zero")
+		return Value{}, false
+	}
+
+	switch x.Kind {
+	case ValueInteger:
+		return r.evalInteger(e, x.Int, y.Int)
+	case ValueFloat:
+		switch e.Op {
+		case OpAdd:
+			return Value{Kind: ValueFloat, Float: x.Float + y.Float}, true
+		case OpSub:
+			return Value{Kind: ValueFloat, Float: x.Float - y.Float}, true
+		case OpMul:
+			return Value{Kind: ValueFloat, Float: x.Float * y.Float}, true
+		case OpDiv:
+			return Value{Kind: ValueFloat, Float: x.Float / y.Float}, true
+		}
+	case ValueFixed:
+		v := Value{Kind: ValueFixed, Fixed: new(big.Rat)}
+		switch e.Op {
+		case OpAdd:
+			v.Fixed.Add(x.Fixed, y.Fixed)
+			v.Scale = max(x.Scale, y.Scale)
+			return v, true
+		case OpSub:
+			v.Fixed.Sub(x.Fixed, y.Fixed)
+			v.Scale = max(x.Scale, y.Scale)
+			return v, true
+		case OpMul:
+			v.Fixed.Mul(x.Fixed, y.Fixed)
+			v.Scale = min(x.Scale+y.Scale, maxFixedDigits)
+			return v, true
+		case OpDiv:
+			v.Fixed.Quo(x.Fixed, y.Fixed)
+			v.Scale = fixedScale(v.Fixed, max(x.Scale, y.Scale))
+			return v, true
+		}
+	}
+
+	r.errorf(CodeTypeMismatch, e.OpPos, "operator %s not defined on %s values", e.Op, x.Kind)
+	return Value{}, false
+}
+
+// Apply a binary operator to two integers.
+func (r *resolver) evalInteger(e *BinaryExpr, x *big.Int, y *big.Int) (Value, bool) {
+	v := Value{Kind: ValueInteger, Int: new(big.Int)}
+	switch e.Op {
+	case OpOr:
+		v.Int.Or(x, y)
+	case OpXor:
+		v.Int.Xor(x, y)
+	case OpAnd:
+		v.Int.And(x, y)
+	case OpShl, OpShr:
+		if y.Sign() < 0 || y.Cmp(big.NewInt(64)) >= 0 {
+			r.errorf(CodeOutOfRange, e.Y.Pos(), "shift count %s out of range 0..63", y)
+			return Value{}, false
+		}
+		if e.Op == OpShl {
+			v.Int.Lsh(x, uint(y.Uint64()))
+		} else {
+			v.Int.Rsh(x, uint(y.Uint64()))
+		}
+	case OpAdd:
+		v.Int.Add(x, y)
+	case OpSub:
+		v.Int.Sub(x, y)
+	case OpMul:
+		v.Int.Mul(x, y)
+	case OpDiv:
+		// Division and remainder truncate towards zero, as in C.
+		v.Int.Quo(x, y)
+	case OpMod:
+		v.Int.Rem(x, y)
+	}
+	return r.checkInteger(v, e.OpPos)
+}
+
+// Check that an integer result fits in the widest IDL integer types.
+func (r *resolver) checkInteger(v Value, pos Position) (Value, bool) {
+	if v.Kind != ValueInteger {
+		return v, true
+	}
+	if v.Int.Cmp(minInteger) < 0 || v.Int.Cmp(maxInteger) > 0 {
+		r.errorf(CodeOutOfRange, pos, "integer overflow: %s", v)
+		return Value{}, false
+	}
+	return v, true
+}
+
+// Convert the result of an expression to the type of the constant it is
+// for.
+func (r *resolver) convert(v Value, t constType, pos Position) (Value, bool) {
+	if t.any {
+		return v, true
+	}
+	if v.Kind == ValueInteger && (t.kind == ValueFloat || t.kind == ValueFixed) {
+		v = promote(v, t.kind)
+	}
+	if v.Kind == ValueChar && t.kind == ValueWChar {
+		v.Kind = ValueWChar
+	}
+
+	if v.Kind != t.kind {
+		r.errorf(CodeTypeMismatch, pos, "cannot use %s value %s as %s", v.Kind, v, t.name)
+		return Value{}, false
+	}
+
+	switch t.kind {
+	case ValueInteger:
+		if v.Int.Cmp(t.min) < 0 || v.Int.Cmp(t.max) > 0 {
+			r.errorf(CodeOutOfRange, pos, "value %s out of range for %s", v, t.name)
+			return Value{}, false
+		}
+	case ValueFloat:
+		if t.float32 && math.Abs(v.Float) > math.MaxFloat32 && !math.IsInf(v.Float, 0) {
+			r.errorf(CodeOutOfRange, pos, "value %s out of range for %s", v, t.name)
+			return Value{}, false
+		}
+	case ValueFixed:
+		if fixedDigits(v) > maxFixedDigits {
+			r.errorf(CodeOutOfRange, pos, "value %s has more than %d digits", v, maxFixedDigits)
+			return Value{}, false
+		}
+	case ValueString, ValueWString:
+		if t.bound > 0 && utf8.RuneCountInString(v.Str) > t.bound {
+			r.errorf(CodeOutOfRange, pos, "string %s is longer than %s", v, t.name)
+			return Value{}, false
+		}
+	case ValueEnumerator:
+		if r.symbols[v.Str].enum != t.enum {
+			r.errorf(CodeTypeMismatch, pos, "enumerator %s is not a %s", v, t.name)
+			return Value{}, false
+		}
+	}
+	return v, true
+}
+
+// Turn an integer into a floating or fixed point number.
+func promote(v Value, kind ValueKind) Value {
+	switch kind {
+	case ValueFloat:
+		f, _ := new(big.Float).SetInt(v.Int).Float64()
+		return Value{Kind: ValueFloat, Float: f}
+	case ValueFixed:
+		return Value{Kind: ValueFixed, Fixed: new(big.Rat).SetInt(v.Int)}
+	}
+	return v
+}
+
+func isZero(v Value) bool {
+	switch v.Kind {
+	case ValueInteger:
+		return v.Int.Sign() == 0
+	case ValueFloat:
+		return v.Float == 0
+	case ValueFixed:
+		return v.Fixed.Sign() == 0
+	}
+	return false
+}
+
+// Find the fewest digits after the decimal point (but at least minScale)
+// that represent r exactly, within the digits a fixed point number can have.
+func fixedScale(r *big.Rat, minScale int) int {
+	limit := maxFixedDigits
+	if whole := new(big.Int).Quo(r.Num(), r.Denom()); whole.Sign() != 0 {
+		limit -= len(new(big.Int).Abs(whole).String())
+	}
+
+	scale := minScale
+	n := new(big.Rat).Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)))
+	for !n.IsInt() && scale < limit {
+		n.Mul(n, big.NewRat(10, 1))
+		scale++
+	}
+	return scale
+}
+
+// Count the digits of a fixed point number, as written with its scale.
+// Leading zeros, as in 0.5, are not digits of the number.
+func fixedDigits(v Value) int {
+	digits := 0
+	for _, c := range strings.TrimLeft(v.Fixed.FloatString(v.Scale), "-0") {
+		if c >= '0' && c <= '9' {
+			digits++
+		}
+	}
+	return digits
+}
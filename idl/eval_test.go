@@ -0,0 +1,134 @@
+package idl
+
+import (
+	"strings"
+	"testing"
+)
+
+// The messages of the diagnostics in err, one per line.
+func messages(t *testing.T, err error) string {
+	t.Helper()
+	msgs := []string{}
+	for _, d := range diagnostics(t, err) {
+		msgs = append(msgs, d.Msg)
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func TestEvalConstants(t *testing.T) {
+	tests := []struct {
+		// Declarations, the last of which is a constant X
+		src   string
+		value string
+		err   string
+	}{
+		{"const long X = 1 + 2 * 3;", "7", ""},
+		{"const long X = (1 + 2) * 3;", "9", ""},
+		{"const long X = 1 | 6 & 3 ^ 8;", "11", ""},
+		{"const long X = 7 / 2;", "3", ""},
+		{"const long X = -7 / 2;", "-3", ""},
+		{"const long X = -7 % 2;", "-1", ""},
+		{"const long X = 1 << 4 >> 2;", "4", ""},
+		{"const long X = ~0;", "-1", ""},
+		{"const unsigned long X = ~0;", "4294967295", ""},
+		{"const unsigned short X = ~1;", "65534", ""},
+		{"const unsigned long long X = 1 << 63;", "9223372036854775808", ""},
+		{"const long long X = -9223372036854775807 - 1;", "-9223372036854775808", ""},
+		{"const octet X = 0xff;", "255", ""},
+		{"const int8 X = -128;", "-128", ""},
+		{"const long A = 2; const long X = A * A;", "4", ""},
+		{"const long X = A + 1; const long A = 2;", "3", ""},
+		{"typedef long T; const T X = 5;", "5", ""},
+		{"module M { const long A = 1; }; const long X = M::A + ::M::A;", "2", ""},
+		{"const double X = 2.5 * 2;", "5", ""},
+		{"const double X = 1 / 4.0;", "0.25", ""},
+		{"const float X = 1.5;", "1.5", ""},
+		{"const double X = 3;", "3", ""},
+		{"const fixed X = 1.5d + 2;", "3.5d", ""},
+		{"const fixed X = 1.25d * 2.5d;", "3.125d", ""},
+		{"const fixed X = 1.0d / 3.0d;", "0.3333333333333333333333333333333d", ""},
+		{"const fixed X = 0.5d;", "0.5d", ""},
+		{"const fixed X = 10000000000000000000000000000000d;", "(invalid)", "value 10000000000000000000000000000000d has more than 31 digits"},
+		{"const boolean X = TRUE;", "TRUE", ""},
+		{`const string X = "abc";`, `"abc"`, ""},
+		{`const string<3> X = "abc";`, `"abc"`, ""},
+		{"const char X = 'a';", "'a'", ""},
+		{"const wchar X = 'a';", "L'a'", ""},
+
+		{"const long X = 1 << 64;", "(invalid)", "shift count 64 out of range 0..63"},
+		{"const long X = 1 >> -1;", "(invalid)", "shift count -1 out of range 0..63"},
+		{"const long X = 1 / 0;", "(invalid)", "division by zero"},
+		{"const long X = 1 % 0;", "(invalid)", "division by zero"},
+		{"const double X = 1.0 / 0;", "(invalid)", "division by zero"},
+		{"const long X = 2147483648;", "(invalid)", "value 2147483648 out of range for long"},
+		{"const short X = -32769;", "(invalid)", "value -32769 out of range for short"},
+		{"const unsigned long X = -1;", "(invalid)", "value -1 out of range for unsigned long"},
+		{"const octet X = 256;", "(invalid)", "value 256 out of range for octet"},
+		{"const unsigned long long X = 18446744073709551615 + 1;", "(invalid)", "integer overflow: 18446744073709551616"},
+		{"const long long X = -9223372036854775807 - 2;", "(invalid)", "integer overflow: -9223372036854775809"},
+		{"const unsigned long X = ~-1;", "(invalid)", "value -1 out of range for unsigned long"},
+		{"const float X = 1e39;", "(invalid)", "value 1e+39 out of range for float"},
+		{`const string<2> X = "abc";`, "(invalid)", `string "abc" is longer than string<2>`},
+		{`const long X = "x";`, "(invalid)", `cannot use string value "x" as long`},
+		{"const string X = 1;", "(invalid)", "cannot use integer value 1 as string"},
+		{`const string X = "a" + "b";`, "(invalid)", "operator + not defined on string values"},
+		{`const long X = "a" + 1;`, "(invalid)", "mismatched types string and integer for operator +"},
+		{"const boolean X = -TRUE;", "(invalid)", "operator - not defined on boolean values"},
+		{"const double X = 1.5 % 2;", "(invalid)", "operator % not defined on floating point values"},
+		{"const long X = Y;", "(invalid)", "unknown name Y"},
+		{"const long X = X;", "(invalid)", "constant X is defined in terms of itself"},
+		{"struct S { long a; }; const long X = S;", "(invalid)", "struct S is not a constant"},
+		{"struct S { long a; }; const S X = 1;", "(invalid)", "struct S cannot be the type of a constant"},
+		{"const Nope X = 1;", "(invalid)", "unknown type Nope"},
+	}
+
+	for _, test := range tests {
+		m, err := parseString(t, test.src)
+		var x *Constant
+		for i := range m.Constants {
+			if m.Constants[i].Name == "X" {
+				x = &m.Constants[i]
+			}
+		}
+		if x == nil {
+			if test.value != "(invalid)" {
+				t.Errorf("%q: no constant X", test.src)
+			}
+			continue
+		}
+		if got := x.Value.String(); got != test.value {
+			t.Errorf("%q: got value %s, want %s", test.src, got, test.value)
+		}
+		if got := messages(t, err); got != test.err {
+			t.Errorf("%q: got errors %q, want %q", test.src, got, test.err)
+		}
+	}
+}
+
+func TestEvalEnumerators(t *testing.T) {
+	src := `
+enum Color { RED, @value(5) GREEN, BLUE };
+const Color X = BLUE;
+const Color Y = ::RED;
+`
+	m, err := parseString(t, src)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := []struct {
+		name string
+		int  int64
+	}{{"BLUE", 6}, {"RED", 0}}
+	for i, w := range want {
+		v := m.Constants[i].Value
+		if v.Kind != ValueEnumerator || v.Str != w.name || v.Int.Int64() != w.int {
+			t.Errorf("%s: got %s value %s (%s), want enumerator %s (%d)", m.Constants[i].Name, v.Kind, v, v.Int, w.name, w.int)
+		}
+	}
+
+	_, err = parseString(t, "enum A { A1 }; enum B { B1 }; const A X = B1; const long Y = A1;")
+	want2 := "enumerator B1 is not a A\ncannot use enumerator value A1 as long"
+	if got := messages(t, err); got != want2 {
+		t.Errorf("got errors %q, want %q", got, want2)
+	}
+}
@@ -0,0 +1,167 @@
+package idl
+
+import (
+	"fmt"
+)
+
+// An Operator is an operator in a constant expression.
+type Operator int
+
+const (
+	// OpOr is a bitwise or, |
+	OpOr Operator = iota
+
+	// OpXor is a bitwise exclusive or, ^
+	OpXor
+
+	// OpAnd is a bitwise and, &
+	OpAnd
+
+	// OpShl is a left shift, <<
+	OpShl
+
+	// OpShr is a right shift, >>
+	OpShr
+
+	// OpAdd is an addition, or unary plus, +
+	OpAdd
+
+	// OpSub is a subtraction, or unary minus, -
+	OpSub
+
+	// OpMul is a multiplication, *
+	OpMul
+
+	// OpDiv is a division, /
+	OpDiv
+
+	// OpMod is a remainder, %
+	OpMod
+
+	// OpNot is a bitwise complement, ~
+	OpNot
+)
+
+// Turn an Operator into a string.
+func (op Operator) String() string {
+	switch op {
+	case OpOr:
+		return "|"
+	case OpXor:
+		return "^"
+	case OpAnd:
+		return "&"
+	case OpShl:
+		return "<<"
+	case OpShr:
+		return ">>"
+	case OpAdd:
+		return "+"
+	case OpSub:
+		return "-"
+	case OpMul:
+		return "*"
+	case OpDiv:
+		return "/"
+	case OpMod:
+		return "%"
+	case OpNot:
+		return "~"
+	}
+	return fmt.Sprintf("Operator(%d)", int(op))
+}
+
+// Expr is a constant expression, e.g. the value of a constant:
+// "2 * SIZE + 1". It is one of *LiteralExpr, *NameExpr, *UnaryExpr,
+// *BinaryExpr or *ParenExpr.
+type Expr interface {
+	// Where the expression starts in the source
+	Pos() Position
+
+	// The expression, written as IDL
+	String() string
+
+	exprNode()
+}
+
+// LiteralExpr is a literal value in an expression, e.g. 42 or "foo".
+type LiteralExpr struct {
+	// The value of the literal
+	Value Value
+
+	// The literal as written, e.g. 0x2A
+	Text string
+
+	// Where the literal is written
+	ValuePos Position
+}
+
+// NameExpr is a reference to a constant or enumerator in an expression, e.g.
+// FOO or Outer::FOO.
+type NameExpr struct {
+	// The (possibly scoped) name, as written
+	Name string
+
+	// Where the name is written
+	NamePos Position
+}
+
+// UnaryExpr is a unary operator applied to an expression, e.g. -X or ~X.
+type UnaryExpr struct {
+	// The operator: OpAdd, OpSub or OpNot
+	Op Operator
+
+	// The operand
+	X Expr
+
+	// Where the operator is written
+	OpPos Position
+}
+
+// BinaryExpr is a binary operator applied to two expressions, e.g. X << 2.
+type BinaryExpr struct {
+	// The operator
+	Op Operator
+
+	// The left hand operand
+	X Expr
+
+	// The right hand operand
+	Y Expr
+
+	// Where the operator is written
+	OpPos Position
+}
+
+// ParenExpr is an expression in parentheses, e.g. (X + 1).
+type ParenExpr struct {
+	// The expression inside the parentheses
+	X Expr
+
+	// Where the opening parenthesis is written
+	Lparen Position
+}
+
+func (e *LiteralExpr) Pos() Position { return e.ValuePos }
+func (e *NameExpr) Pos() Position    { return e.NamePos }
+func (e *UnaryExpr) Pos() Position   { return e.OpPos }
+func (e *BinaryExpr) Pos() Position  { return e.X.Pos() }
+func (e *ParenExpr) Pos() Position   { return e.Lparen }
+
+func (e *LiteralExpr) String() string {
+	if e.Text != "" {
+		return e.Text
+	}
+	return e.Value.String()
+}
+
+func (e *NameExpr) String() string   { return e.Name }
+func (e *UnaryExpr) String() string  { return e.Op.String() + e.X.String() }
+func (e *BinaryExpr) String() string { return fmt.Sprintf("%s %s %s", e.X, e.Op, e.Y) }
+func (e *ParenExpr) String() string  { return "(" + e.X.String() + ")" }
+
+func (*LiteralExpr) exprNode() {}
+func (*NameExpr) exprNode()    {}
+func (*UnaryExpr) exprNode()   {}
+func (*BinaryExpr) exprNode()  {}
+func (*ParenExpr) exprNode()   {}
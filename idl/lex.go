@@ -2,16 +2,23 @@ package idl
 
 import (
 	"fmt"
+	"math/big"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
-const lexDebug = false
-
 // A TokenID represents a type of token in an IDL file.
 type TokenID int
 
 // Turn a TokenID into a string.
 func (tok TokenID) String() string {
+	return fmt.Sprintf("%s(%d)", tok.symbol(), tok)
+}
+
+// Return how a token of this type is written, or a description of it if it
+// has a value.
+func (tok TokenID) symbol() string {
 	val := ""
 	switch tok {
 	case TokenIdentifier:
@@ -20,6 +27,28 @@ func (tok TokenID) String() string {
 		val = "#"
 	case TokenStringLiteral:
 		val = "quoted string"
+	case TokenWideStringLiteral:
+		val = "wide quoted string"
+	case TokenCharLiteral:
+		val = "character"
+	case TokenWideCharLiteral:
+		val = "wide character"
+	case TokenPipe:
+		val = "|"
+	case TokenCaret:
+		val = "^"
+	case TokenAmpersand:
+		val = "&"
+	case TokenStar:
+		val = "*"
+	case TokenSlash:
+		val = "/"
+	case TokenPercent:
+		val = "%"
+	case TokenTilde:
+		val = "~"
+	case TokenAt:
+		val = "@"
 	case TokenColon:
 		val = ":"
 	case TokenSemicolon:
@@ -50,11 +79,41 @@ func (tok TokenID) String() string {
 		val = ">"
 	case TokenNamespace:
 		val = "::"
+	case TokenComment:
+		val = "comment"
+	case TokenIntegerLiteral:
+		val = "integer"
+	case TokenFloatLiteral:
+		val = "floating point number"
+	case TokenFixedLiteral:
+		val = "fixed point number"
+	case TokenMinus:
+		val = "-"
+	case TokenPlus:
+		val = "+"
+	case TokenExclamation:
+		val = "!"
+	case TokenQuestion:
+		val = "?"
+	case TokenLogicalAnd:
+		val = "&&"
+	case TokenLogicalOr:
+		val = "||"
+	case TokenEqualEqual:
+		val = "=="
+	case TokenNotEqual:
+		val = "!="
+	case TokenLessEqual:
+		val = "<="
+	case TokenGreaterEqual:
+		val = ">="
+	case TokenHeaderName:
+		val = "header name"
 	default:
 		val = "(wtf)"
 	}
 
-	return fmt.Sprintf("%s(%d)", val, tok)
+	return val
 }
 
 const (
@@ -109,6 +168,89 @@ const (
 	// TokenNamespace represents a namespace separator (::) used in types.
 	TokenNamespace
 
+	// TokenComment is a // or /* */ comment. The value holds the comment's
+	// text, including the comment markers.
+	TokenComment
+
+	// TokenIntegerLiteral is an integer, in decimal, octal or hexadecimal.
+	TokenIntegerLiteral
+
+	// TokenFloatLiteral is a floating point number, e.g. 3.14 or 1e-9.
+	TokenFloatLiteral
+
+	// TokenFixedLiteral is a fixed point number, e.g. 10.5d.
+	TokenFixedLiteral
+
+	// TokenMinus is a - character.
+	TokenMinus
+
+	// TokenPlus is a + character.
+	TokenPlus
+
+	// TokenWideStringLiteral represents a quoted wide string, e.g. L"foo"
+	TokenWideStringLiteral
+
+	// TokenCharLiteral represents a quoted character, e.g. 'a'
+	TokenCharLiteral
+
+	// TokenWideCharLiteral represents a quoted wide character, e.g. L'a'
+	TokenWideCharLiteral
+
+	// TokenPipe is a | character.
+	TokenPipe
+
+	// TokenCaret is a ^ character.
+	TokenCaret
+
+	// TokenAmpersand is a & character.
+	TokenAmpersand
+
+	// TokenStar is a * character.
+	TokenStar
+
+	// TokenSlash is a / character that does not start a comment.
+	TokenSlash
+
+	// TokenPercent is a % character.
+	TokenPercent
+
+	// TokenTilde is a ~ character.
+	TokenTilde
+
+	// TokenAt is a @ character, starting an annotation.
+	TokenAt
+
+	// The following are only meaningful in preprocessor expressions, as in
+	// "#if defined(FOO) && BAR >= 2".
+
+	// TokenExclamation is a ! character.
+	TokenExclamation
+
+	// TokenQuestion is a ? character.
+	TokenQuestion
+
+	// TokenLogicalAnd is &&.
+	TokenLogicalAnd
+
+	// TokenLogicalOr is ||.
+	TokenLogicalOr
+
+	// TokenEqualEqual is ==.
+	TokenEqualEqual
+
+	// TokenNotEqual is !=.
+	TokenNotEqual
+
+	// TokenLessEqual is <=.
+	TokenLessEqual
+
+	// TokenGreaterEqual is >=.
+	TokenGreaterEqual
+
+	// TokenHeaderName is the file name of an #include <...>, without the
+	// angle brackets.
+	TokenHeaderName
+
 	// TokenInvalid is a non-existent token used in error handling.
 	TokenInvalid
 )
@@ -122,6 +264,20 @@ type Token struct {
 	// Represents the associated data of a token. For instance, TokenWord will
 	// have a value containing the word that was lexed.
 	Value string
+
+	// Where the token starts in the source
+	Pos Position
+
+	// Where the token ends in the source (exclusive)
+	End Position
+
+	// The parsed value of a literal token. This is nil for other tokens.
+	Literal *Value
+
+	// Whether the token follows the one before it without even whitespace in
+	// between, as the two < of a << do. This is kept when the token comes
+	// from a macro, whose tokens all share the position of its use.
+	Adjacent bool
 }
 
 // Turn a Token into a string
@@ -136,43 +292,118 @@ func (tok Token) String() string {
 // tokens. The token series can then be interpreted directly, or parsed to
 // ensure validity and become usable in a higher form.
 type lexer struct {
-	buf    []byte
-	pos    int
-	errors []error
-	tokens []Token
+	buf      []byte
+	filename string
+	opts     ParseOptions
+	pos      int
+	errors   ErrorList
+	tokens   []Token
+
+	// offset of the token currently being lexed
+	start int
+
+	// line tracking, see position()
+	line      int
+	lineStart int
+	scanned   int
 }
 
-// Add the given token to the stream
-func (l *lexer) pushToken(tok TokenID, val string) {
-	if lexDebug {
-		if len(val) > 0 {
-			fmt.Printf("Lexed token %s val %s\n", tok, val)
-		} else {
-			fmt.Printf("Lexed token %s\n", tok)
+// Turn a byte offset into a Position. Offsets are usually requested in
+// increasing order, so the line count is kept incrementally.
+func (l *lexer) position(offset int) Position {
+	if offset < l.scanned {
+		l.line, l.lineStart, l.scanned = 1, 0, 0
+	}
+	for ; l.scanned < offset && l.scanned < len(l.buf); l.scanned++ {
+		if l.buf[l.scanned] == '\n' {
+			l.line++
+			l.lineStart = l.scanned + 1
 		}
 	}
-	l.tokens = append(l.tokens, Token{tok, val})
+	return Position{
+		Filename: l.filename,
+		Offset:   offset,
+		Line:     l.line,
+		Column:   offset - l.lineStart + 1,
+	}
 }
 
-func (l *lexer) reportError(err error) {
-	l.errors = append(l.errors, err)
+// Add the given token to the stream
+func (l *lexer) pushToken(tok TokenID, val string) {
+	l.pushLiteral(tok, val, nil)
 }
 
-func (l *lexer) hasError() bool {
-	return len(l.errors) != 0
+// Add the given token to the stream, with the value of a literal
+func (l *lexer) pushLiteral(tok TokenID, val string, lit *Value) {
+	if len(val) > 0 {
+		l.opts.debugf("Lexed token %s val %s", tok, val)
+	} else {
+		l.opts.debugf("Lexed token %s", tok)
+	}
+	n := len(l.tokens)
+	l.tokens = append(l.tokens, Token{
+		ID:    tok,
+		Value: val,
+		Pos:   l.position(l.start),
+		End:   l.position(l.pos + 1),
+
+		Literal:  lit,
+		Adjacent: n > 0 && l.tokens[n-1].End.Offset == l.start && !isSkippedToken(l.tokens[n-1].ID),
+	})
+}
+
+// Report an error covering the token being lexed, up to the current
+// position. Lexing carries on afterwards.
+func (l *lexer) reportError(code DiagnosticCode, format string, args ...interface{}) {
+	d := &Diagnostic{
+		Code:     code,
+		Severity: SeverityError,
+		Pos:      l.position(l.start),
+		End:      l.position(l.pos),
+		Msg:      fmt.Sprintf(format, args...),
+	}
+	l.opts.report(d)
+	l.errors.Add(d)
 }
 
 func (l *lexer) cur() byte {
 	return l.buf[l.pos]
 }
 
+// Skip spaces and tabs, and backslashes at the end of a line, which join it
+// to the next (for long #defines).
 func (l *lexer) skipWhitespace() {
-	for !l.atEnd() && (l.cur() == ' ' || l.cur() == '\t') {
+	for !l.atEnd() {
+		switch {
+		case l.cur() == ' ' || l.cur() == '\t':
+			l.advance()
+		case l.cur() == '\\' && l.followedBy("\n"):
+			l.pos += 2
+		case l.cur() == '\\' && l.followedBy("\r\n"):
+			l.pos += 3
+		default:
+			return
+		}
+	}
+}
+
+// Is the current character followed by the given ones?
+func (l *lexer) followedBy(s string) bool {
+	return strings.HasPrefix(string(l.buf[l.pos+1:]), s)
+}
+
+// Push a token of two characters if the next character is second, or of one
+// character otherwise.
+func (l *lexer) pushOneOrTwo(one TokenID, second byte, two TokenID) {
+	if l.followedBy(string(second)) {
 		l.advance()
+		l.pushToken(two, "")
+	} else {
+		l.pushToken(one, "")
 	}
 }
 
-func (l *lexer) readUntilNot(delims []byte) ([]byte, error) {
+func (l *lexer) readUntilNot(delims []byte) []byte {
 	buf := []byte{}
 	found := true
 	for !l.atEnd() && found {
@@ -193,10 +424,12 @@ func (l *lexer) readUntilNot(delims []byte) ([]byte, error) {
 	}
 
 	if l.atEnd() {
-		return nil, fmt.Errorf("didn't find %s, when I wanted it", delims)
+		// Ran into the end of the buffer; leave the position on the last
+		// character read, as we would otherwise.
+		l.rewind()
 	}
 
-	return buf, nil
+	return buf
 }
 
 func (l *lexer) readUntilMany(delims []byte) ([]byte, error) {
@@ -244,41 +477,246 @@ func (l *lexer) rewind() {
 }
 
 func (l *lexer) lexComment() {
-	if l.next() == '/' {
-		l.readUntil('\n')
+	if l.pos+1 >= len(l.buf) {
+		l.pushToken(TokenSlash, "")
+		return
+	}
+
+	switch l.next() {
+	case '/':
+		// Leave the newline to be lexed on its own, as it still ends the
+		// line (e.g. for a #define).
+		buf, err := l.readUntil('\n')
+		if err == nil {
+			l.rewind()
+		} else {
+			// comment on the last line, without a newline
+			buf = l.buf[l.start:]
+			l.pos = len(l.buf) - 1
+		}
+		l.pushToken(TokenComment, strings.TrimRight(string(buf), "\r"))
+	case '*':
+		l.lexBlockComment()
+	default:
+		l.pushToken(TokenSlash, "")
+	}
+}
+
+// Skip a /* */ comment, which may span several lines.
+func (l *lexer) lexBlockComment() {
+	// skip /*
+	l.advance()
+	l.advance()
+
+	for !l.atEnd() {
+		if l.cur() == '*' && l.pos+1 < len(l.buf) && l.next() == '/' {
+			// stop on the final /
+			l.advance()
+			l.pushToken(TokenComment, string(l.buf[l.start:l.pos+1]))
+			return
+		}
+		l.advance()
 	}
+
+	l.reportError(CodeUnterminatedComment, "unterminated block comment")
+}
+
+// Is the lexer just after "#include"?
+func (l *lexer) inInclude() bool {
+	n := len(l.tokens)
+	return n >= 2 && l.tokens[n-2].ID == TokenHash &&
+		l.tokens[n-1].ID == TokenIdentifier && l.tokens[n-1].Value == "include"
+}
+
+// Lex the <file> of an #include.
+func (l *lexer) lexHeaderName() {
+	for l.pos+1 < len(l.buf) && l.next() != '>' && l.next() != '\n' {
+		l.advance()
+	}
+	if l.pos+1 >= len(l.buf) || l.next() != '>' {
+		l.reportError(CodeUnterminatedString, "unterminated file name")
+		return
+	}
+	l.advance()
+	l.pushToken(TokenHeaderName, string(l.buf[l.start+1:l.pos]))
 }
 
-func (l *lexer) lexStringLiteral() {
+// Lex a string literal, "foo", or a wide one, L"foo" (wide is true, and the
+// lexer is on the L).
+func (l *lexer) lexStringLiteral(wide bool) {
+	if wide {
+		l.advance()
+	}
 	if l.cur() != '"' {
-		l.reportError(fmt.Errorf("expected: \", got: %c", l.cur()))
+		l.reportError(CodeInvalidCharacter, "expected: \", got: %c", l.cur())
 		return
 	}
 
-	// skip "
+	str, ok := l.readQuoted('"', wide)
+	if !ok {
+		l.reportError(CodeUnterminatedString, "unterminated string literal")
+	}
+	if strings.IndexByte(str, 0) >= 0 {
+		l.reportError(CodeInvalidEscape, "string literals may not contain a null character")
+	}
+
+	if wide {
+		l.pushLiteral(TokenWideStringLiteral, str, &Value{Kind: ValueWString, Str: str})
+	} else {
+		l.pushLiteral(TokenStringLiteral, str, &Value{Kind: ValueString, Str: str})
+	}
+}
+
+// Lex a character literal, 'a', or a wide one, L'a' (wide is true, and the
+// lexer is on the L).
+func (l *lexer) lexCharLiteral(wide bool) {
+	if wide {
+		l.advance()
+	}
+
+	str, ok := l.readQuoted('\'', wide)
+	if !ok {
+		l.reportError(CodeUnterminatedString, "unterminated character literal")
+	}
+
+	r, size := utf8.DecodeRuneInString(str)
+	if ok && (size == 0 || size != len(str)) {
+		l.reportError(CodeInvalidCharacter, "character literal must hold exactly one character")
+	}
+	if !wide && size > 0 && r > 0xff {
+		l.reportError(CodeInvalidCharacter, "character %q does not fit in a char", r)
+	}
+
+	if wide {
+		l.pushLiteral(TokenWideCharLiteral, str, &Value{Kind: ValueWChar, Char: r})
+	} else {
+		l.pushLiteral(TokenCharLiteral, str, &Value{Kind: ValueChar, Char: r})
+	}
+}
+
+// Read the contents of a quoted literal, starting on the opening quote, and
+// decoding escape sequences. Afterwards, the lexer is on the closing quote.
+// Returns false if the literal is unterminated; literals may not span lines.
+func (l *lexer) readQuoted(quote byte, wide bool) (string, bool) {
+	var sb strings.Builder
+
+	// skip the opening quote
 	l.advance()
 
-	buf, err := l.readUntil('"')
-	if err != nil {
-		l.reportError(fmt.Errorf("unterminated string literal"))
+	for !l.atEnd() {
+		switch c := l.cur(); c {
+		case quote:
+			return sb.String(), true
+		case '\n':
+			// leave the newline to be lexed
+			l.rewind()
+			return sb.String(), false
+		case '\\':
+			sb.WriteRune(l.readEscape(wide))
+		default:
+			r, size := utf8.DecodeRune(l.buf[l.pos:])
+			sb.WriteRune(r)
+			l.pos += size - 1
+		}
+		l.advance()
+	}
+
+	l.rewind()
+	return sb.String(), false
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// Decode an escape sequence in a literal, starting on the backslash. The
+// lexer is left on the last character of the sequence.
+func (l *lexer) readEscape(wide bool) rune {
+	if l.pos+1 >= len(l.buf) {
+		return '\\'
+	}
+	l.advance()
+
+	// Read up to max more digits of the given base, and add them to val.
+	readDigits := func(val rune, base rune, max int, valid func(byte) bool) rune {
+		for i := 0; i < max && l.pos+1 < len(l.buf) && valid(l.next()); i++ {
+			l.advance()
+			d, _ := strconv.ParseUint(string(l.cur()), 16, 8)
+			val = val*base + rune(d)
+		}
+		return val
+	}
+
+	switch c := l.cur(); c {
+	case 'n':
+		return '\n'
+	case 't':
+		return '\t'
+	case 'v':
+		return '\v'
+	case 'b':
+		return '\b'
+	case 'r':
+		return '\r'
+	case 'f':
+		return '\f'
+	case 'a':
+		return '\a'
+	case '\\', '?', '\'', '"':
+		return rune(c)
+	case '0', '1', '2', '3', '4', '5', '6', '7':
+		// \ooo
+		isOctal := func(c byte) bool { return c >= '0' && c <= '7' }
+		return readDigits(rune(c-'0'), 8, 2, isOctal)
+	case 'x':
+		// \xhh
+		if l.pos+1 >= len(l.buf) || !isHexDigit(l.next()) {
+			l.reportError(CodeInvalidEscape, "\\x used with no following hex digits")
+			return utf8.RuneError
+		}
+		return readDigits(0, 16, 2, isHexDigit)
+	case 'u':
+		// \uhhhh
+		if !wide {
+			l.reportError(CodeInvalidEscape, "\\u escapes are only allowed in wide literals")
+		}
+		if l.pos+1 >= len(l.buf) || !isHexDigit(l.next()) {
+			l.reportError(CodeInvalidEscape, "\\u used with no following hex digits")
+			return utf8.RuneError
+		}
+		return readDigits(0, 16, 4, isHexDigit)
 	}
 
-	l.pushToken(TokenStringLiteral, string(buf))
+	l.reportError(CodeInvalidEscape, "unknown escape sequence: \\%c", l.cur())
+	return rune(l.cur())
 }
 
 const (
-	keywordModule    = "module"
-	keywordTypedef   = "typedef"
-	keywordStruct    = "struct"
-	keywordConst     = "const"
-	keywordEnum      = "enum"
-	keywordInterface = "interface"
-	keywordUnion     = "union"
-	keywordIn        = "in"
-	keywordOut       = "out"
-	keywordInOut     = "inout"
-	keywordSwitch    = "switch"
-	keywordCase      = "case"
+	keywordModule     = "module"
+	keywordTypedef    = "typedef"
+	keywordStruct     = "struct"
+	keywordConst      = "const"
+	keywordEnum       = "enum"
+	keywordInterface  = "interface"
+	keywordUnion      = "union"
+	keywordIn         = "in"
+	keywordOut        = "out"
+	keywordInOut      = "inout"
+	keywordSwitch     = "switch"
+	keywordCase       = "case"
+	keywordDefault    = "default"
+	keywordException  = "exception"
+	keywordAttribute  = "attribute"
+	keywordReadonly   = "readonly"
+	keywordOneway     = "oneway"
+	keywordRaises     = "raises"
+	keywordGetRaises  = "getraises"
+	keywordSetRaises  = "setraises"
+	keywordContext    = "context"
+	keywordBitmask    = "bitmask"
+	keywordBitset     = "bitset"
+	keywordBitfield   = "bitfield"
+	keywordAnnotation = "annotation"
 )
 
 // ### this needs to be improved to read types properly.
@@ -286,35 +724,158 @@ const (
 var validInIdentifiers = []byte("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890_")
 
 func (l *lexer) lexWord() {
-	buf, err := l.readUntilNot(validInIdentifiers)
+	buf := l.readUntilNot(validInIdentifiers)
+	l.pushToken(TokenIdentifier, string(buf))
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Is the lexer at the start of a numeric literal?
+func (l *lexer) atNumber() bool {
+	return isDigit(l.cur()) ||
+		(l.cur() == '.' && l.pos+1 < len(l.buf) && isDigit(l.next()))
+}
+
+// Lex an integer, floating point or fixed point literal.
+func (l *lexer) lexNumber() {
+	// Read everything that could belong to the number, and work out whether
+	// it makes sense afterwards. That way, "12abc" is an error rather than
+	// a number followed by a word.
+	isHex := l.cur() == '0' && l.pos+1 < len(l.buf) && (l.next() == 'x' || l.next() == 'X')
+	for !l.atEnd() {
+		c := l.cur()
+		if c == '.' || strings.IndexByte(string(validInIdentifiers), c) >= 0 {
+			l.advance()
+			continue
+		}
+
+		// exponent sign, as in 1e-9
+		prev := l.buf[l.pos-1]
+		if (c == '+' || c == '-') && !isHex && (prev == 'e' || prev == 'E') {
+			l.advance()
+			continue
+		}
+		break
+	}
+	text := string(l.buf[l.start:l.pos])
+	l.rewind()
+
+	tok, lit, err := parseNumber(text)
 	if err != nil {
-		l.reportError(fmt.Errorf("EOF on a word?"))
+		l.reportError(CodeInvalidNumber, "invalid numeric literal %s: %s", text, err)
+		tok, lit = TokenIntegerLiteral, &Value{Kind: ValueInteger, Int: new(big.Int)}
 	}
+	l.pushLiteral(tok, text, lit)
+}
 
-	l.pushToken(TokenIdentifier, string(buf))
+// Work out the type and value of a numeric literal. Integers may be decimal,
+// octal (with a leading 0) or hexadecimal (with a leading 0x). A trailing d or
+// D makes a fixed point literal. C style suffixes (u, l, f) are accepted as
+// some IDL in the wild uses them, but have no effect.
+func parseNumber(text string) (TokenID, *Value, error) {
+	if len(text) >= 2 && text[0] == '0' && (text[1] == 'x' || text[1] == 'X') {
+		digits := strings.TrimRight(text[2:], "uUlL")
+		if digits == "" {
+			return TokenInvalid, nil, fmt.Errorf("hexadecimal literal has no digits")
+		}
+		i, ok := new(big.Int).SetString(digits, 16)
+		if !ok {
+			return TokenInvalid, nil, fmt.Errorf("bad hexadecimal digits")
+		}
+		return TokenIntegerLiteral, &Value{Kind: ValueInteger, Int: i}, nil
+	}
+
+	if last := text[len(text)-1]; last == 'd' || last == 'D' {
+		digits := text[:len(text)-1]
+		if strings.Trim(digits, "0123456789.") != "" || strings.Count(digits, ".") > 1 || digits == "." {
+			return TokenInvalid, nil, fmt.Errorf("bad fixed point digits")
+		}
+		scale := 0
+		if idx := strings.IndexByte(digits, '.'); idx >= 0 {
+			scale = len(digits) - idx - 1
+		}
+		r, ok := new(big.Rat).SetString(strings.TrimSuffix(digits, "."))
+		if !ok {
+			return TokenInvalid, nil, fmt.Errorf("bad fixed point digits")
+		}
+		return TokenFixedLiteral, &Value{Kind: ValueFixed, Fixed: r, Scale: scale}, nil
+	}
+
+	if strings.ContainsAny(text, ".eE") {
+		digits := strings.TrimRight(text, "fFlL")
+		if strings.Trim(digits, "0123456789.eE+-") != "" {
+			return TokenInvalid, nil, fmt.Errorf("bad floating point digits")
+		}
+		f, err := strconv.ParseFloat(digits, 64)
+		if err != nil {
+			return TokenInvalid, nil, fmt.Errorf("bad floating point number")
+		}
+		return TokenFloatLiteral, &Value{Kind: ValueFloat, Float: f}, nil
+	}
+
+	digits := strings.TrimRight(text, "uUlL")
+	base := 10
+	if len(digits) > 1 && digits[0] == '0' {
+		base = 8
+		digits = digits[1:]
+	}
+	i, ok := new(big.Int).SetString(digits, base)
+	if !ok || strings.ContainsAny(digits, "+-_") {
+		if base == 8 {
+			return TokenInvalid, nil, fmt.Errorf("bad octal digits")
+		}
+		return TokenInvalid, nil, fmt.Errorf("bad decimal digits")
+	}
+	return TokenIntegerLiteral, &Value{Kind: ValueInteger, Int: i}, nil
 }
 
 // Lex a buffer of IDL data into tokens.
-// Returns the lexed tokens, and any error encountered.
+// Returns the lexed tokens, and any errors encountered as an ErrorList. The
+// tokens are returned even when there are errors, so that parsing can go on
+// to find more problems.
 func Lex(d []byte) ([]Token, error) {
+	return LexFile("", d)
+}
+
+// LexFile is like Lex, but records filename in the position of every token.
+func LexFile(filename string, d []byte) ([]Token, error) {
+	return LexWithOptions(filename, d, ParseOptions{})
+}
+
+// LexWithOptions is like LexFile, but allows configuring logging and
+// diagnostics.
+func LexWithOptions(filename string, d []byte, opts ParseOptions) ([]Token, error) {
 	l := &lexer{
-		buf: d,
-		pos: 0,
+		buf:      d,
+		filename: filename,
+		opts:     opts,
+		pos:      0,
+		line:     1,
 	}
 
-	for !l.atEnd() && !l.hasError() {
+	for !l.atEnd() {
 		l.skipWhitespace()
 		if l.atEnd() {
 			break
 		}
 
+		l.start = l.pos
+
 		switch {
 		case l.cur() == '/':
 			l.lexComment()
 		case l.cur() == '#':
 			l.pushToken(TokenHash, "")
 		case l.cur() == '"':
-			l.lexStringLiteral()
+			l.lexStringLiteral(false)
+		case l.cur() == '\'':
+			l.lexCharLiteral(false)
+		case l.cur() == 'L' && l.pos+1 < len(l.buf) && l.next() == '"':
+			l.lexStringLiteral(true)
+		case l.cur() == 'L' && l.pos+1 < len(l.buf) && l.next() == '\'':
+			l.lexCharLiteral(true)
 		case l.cur() == '{':
 			l.pushToken(TokenOpenBrace, "")
 		case l.cur() == '}':
@@ -328,35 +889,57 @@ func Lex(d []byte) ([]Token, error) {
 		case l.cur() == ')':
 			l.pushToken(TokenCloseBracket, "")
 		case l.cur() == ':':
-			l.advance()
-			if l.cur() == ':' {
-				l.pushToken(TokenNamespace, "")
-			} else {
-				l.rewind()
-				l.pushToken(TokenColon, "")
-			}
+			l.pushOneOrTwo(TokenColon, ':', TokenNamespace)
 		case l.cur() == ';':
 			l.pushToken(TokenSemicolon, "")
 		case l.cur() == '=':
-			l.pushToken(TokenEquals, "")
+			l.pushOneOrTwo(TokenEquals, '=', TokenEqualEqual)
+		case l.cur() == '!':
+			l.pushOneOrTwo(TokenExclamation, '=', TokenNotEqual)
+		case l.cur() == '?':
+			l.pushToken(TokenQuestion, "")
 		case l.cur() == '\n':
 			l.pushToken(TokenEndLine, "")
 		case l.cur() == ',':
 			l.pushToken(TokenComma, "")
+		case l.cur() == '<' && l.inInclude():
+			l.lexHeaderName()
 		case l.cur() == '<':
-			l.pushToken(TokenLessThan, "")
+			l.pushOneOrTwo(TokenLessThan, '=', TokenLessEqual)
 		case l.cur() == '>':
-			l.pushToken(TokenGreaterThan, "")
+			l.pushOneOrTwo(TokenGreaterThan, '=', TokenGreaterEqual)
+		case l.cur() == '-':
+			l.pushToken(TokenMinus, "")
+		case l.cur() == '+':
+			l.pushToken(TokenPlus, "")
+		case l.cur() == '|':
+			l.pushOneOrTwo(TokenPipe, '|', TokenLogicalOr)
+		case l.cur() == '^':
+			l.pushToken(TokenCaret, "")
+		case l.cur() == '&':
+			l.pushOneOrTwo(TokenAmpersand, '&', TokenLogicalAnd)
+		case l.cur() == '*':
+			l.pushToken(TokenStar, "")
+		case l.cur() == '%':
+			l.pushToken(TokenPercent, "")
+		case l.cur() == '~':
+			l.pushToken(TokenTilde, "")
+		case l.cur() == '@':
+			l.pushToken(TokenAt, "")
+		case l.atNumber():
+			l.lexNumber()
 		case strings.IndexByte(string(validInIdentifiers), l.cur()) >= 0:
 			l.lexWord()
+		case l.cur() == '\r':
+			// part of a Windows line ending
+		default:
+			r, size := utf8.DecodeRune(l.buf[l.pos:])
+			l.pos += size - 1
+			l.reportError(CodeInvalidCharacter, "unexpected character %q", r)
 		}
 
 		l.advance()
 	}
 
-	if l.hasError() {
-		return []Token{}, l.errors[0]
-	}
-
-	return l.tokens, nil
+	return l.tokens, l.errors.Err()
 }
@@ -0,0 +1,114 @@
+package idl
+
+import (
+	"strings"
+	"testing"
+)
+
+// Describe the tokens of src, other than newlines, as "ID(value)" strings,
+// along with the lexer's errors.
+func lexString(t *testing.T, src string) ([]string, []string) {
+	t.Helper()
+	toks, err := LexFile("test.idl", []byte(src))
+	got := []string{}
+	for _, tok := range toks {
+		if tok.ID == TokenEndLine {
+			continue
+		}
+		s := tok.ID.symbol()
+		if tok.Literal != nil {
+			s += "(" + tok.Literal.String() + ")"
+		} else if tok.Value != "" {
+			s += "(" + tok.Value + ")"
+		}
+		got = append(got, s)
+	}
+	errs := []string{}
+	for _, d := range diagnostics(t, err) {
+		errs = append(errs, d.Error())
+	}
+	return got, errs
+}
+
+type lexTest struct {
+	src    string
+	tokens []string
+	errors []string
+}
+
+func runLexTests(t *testing.T, tests []lexTest) {
+	t.Helper()
+	for _, test := range tests {
+		tokens, errs := lexString(t, test.src)
+		if strings.Join(tokens, " ") != strings.Join(test.tokens, " ") {
+			t.Errorf("%q: got tokens\n\t%s\nwant\n\t%s", test.src, strings.Join(tokens, " "), strings.Join(test.tokens, " "))
+		}
+		if strings.Join(errs, "\n") != strings.Join(test.errors, "\n") {
+			t.Errorf("%q: got errors\n\t%s\nwant\n\t%s", test.src, strings.Join(errs, "\n\t"), strings.Join(test.errors, "\n\t"))
+		}
+	}
+}
+
+func TestLexComments(t *testing.T) {
+	runLexTests(t, []lexTest{
+		{"a // b", []string{"identifier(a)", "comment(// b)"}, nil},
+		{"a /* b */ c", []string{"identifier(a)", "comment(/* b */)", "identifier(c)"}, nil},
+		{"/* a\n * b\n */x", []string{"comment(/* a\n * b\n */)", "identifier(x)"}, nil},
+		{"/**/", []string{"comment(/**/)"}, nil},
+		{"/* a /* b */", []string{"comment(/* a /* b */)"}, nil},
+		{"a / b", []string{"identifier(a)", "/", "identifier(b)"}, nil},
+		{"a /", []string{"identifier(a)", "/"}, nil},
+		{"/* a", nil, []string{"test.idl:1:1: unterminated block comment"}},
+		{"/*/", nil, []string{"test.idl:1:1: unterminated block comment"}},
+	})
+}
+
+func TestLexNumbers(t *testing.T) {
+	runLexTests(t, []lexTest{
+		{"0", []string{"integer(0)"}, nil},
+		{"42", []string{"integer(42)"}, nil},
+		{"017", []string{"integer(15)"}, nil},
+		{"0x1f 0XFF", []string{"integer(31)", "integer(255)"}, nil},
+		{"10UL 0x10l", []string{"integer(10)", "integer(16)"}, nil},
+		{"18446744073709551616", []string{"integer(18446744073709551616)"}, nil},
+		{"3.14", []string{"floating point number(3.14)"}, nil},
+		{".5 5. 1e3", []string{"floating point number(0.5)", "floating point number(5)", "floating point number(1000)"}, nil},
+		{"1.5e-3 2E+2 1.0f", []string{"floating point number(0.0015)", "floating point number(200)", "floating point number(1)"}, nil},
+		{"10.50d 3d .5D 7.d", []string{"fixed point number(10.50d)", "fixed point number(3d)", "fixed point number(0.5d)", "fixed point number(7d)"}, nil},
+		{"a-1", []string{"identifier(a)", "-", "integer(1)"}, nil},
+		{"1-2", []string{"integer(1)", "-", "integer(2)"}, nil},
+		{"0x", []string{"integer(0)"}, []string{"test.idl:1:1: invalid numeric literal 0x: hexadecimal literal has no digits"}},
+		{"0xg", []string{"integer(0)"}, []string{"test.idl:1:1: invalid numeric literal 0xg: bad hexadecimal digits"}},
+		{"09", []string{"integer(0)"}, []string{"test.idl:1:1: invalid numeric literal 09: bad octal digits"}},
+		{"12abc", []string{"integer(0)"}, []string{"test.idl:1:1: invalid numeric literal 12abc: bad decimal digits"}},
+		{"1.2.3", []string{"integer(0)"}, []string{"test.idl:1:1: invalid numeric literal 1.2.3: bad floating point number"}},
+		{"1.2.3d", []string{"integer(0)"}, []string{"test.idl:1:1: invalid numeric literal 1.2.3d: bad fixed point digits"}},
+	})
+}
+
+func TestLexStringsAndCharacters(t *testing.T) {
+	runLexTests(t, []lexTest{
+		{`"foo"`, []string{`quoted string("foo")`}, nil},
+		{`""`, []string{`quoted string("")`}, nil},
+		{`"a\tb\n\\\"\'\?"`, []string{`quoted string("a\tb\n\\\"'?")`}, nil},
+		{`"\v\b\r\f\a"`, []string{`quoted string("\v\b\r\f\a")`}, nil},
+		{`"\101\7\x41\x4a"`, []string{`quoted string("A\aAJ")`}, nil},
+		{`"\1012"`, []string{`quoted string("A2")`}, nil},
+		{`L"wé"`, []string{`wide quoted string(L"wé")`}, nil},
+		{`L"\u263a\u00e9x"`, []string{`wide quoted string(L"☺éx")`}, nil},
+		{`"é"`, []string{`quoted string("é")`}, nil},
+		{`'a'`, []string{`character('a')`}, nil},
+		{`'\n' '\'' '\x7f'`, []string{`character('\n')`, `character('\'')`, `character('\x7f')`}, nil},
+		{`L'x' L'☺'`, []string{`wide character(L'x')`, `wide character(L'☺')`}, nil},
+		{`"abc`, []string{`quoted string("abc")`}, []string{"test.idl:1:1: unterminated string literal"}},
+		{"\"abc\nx", []string{`quoted string("abc")`, "identifier(x)"}, []string{"test.idl:1:1: unterminated string literal"}},
+		{`'a`, []string{`character('a')`}, []string{"test.idl:1:1: unterminated character literal"}},
+		{`''`, []string{`character('�')`}, []string{"test.idl:1:1: character literal must hold exactly one character"}},
+		{`'ab'`, []string{`character('a')`}, []string{"test.idl:1:1: character literal must hold exactly one character"}},
+		{`'☺'`, []string{`character('☺')`}, []string{"test.idl:1:1: character '☺' does not fit in a char"}},
+		{`"\q"`, []string{`quoted string("q")`}, []string{`test.idl:1:1: unknown escape sequence: \q`}},
+		{`"\xg"`, []string{`quoted string("�g")`}, []string{`test.idl:1:1: \x used with no following hex digits`}},
+		{`"\u0041"`, []string{`quoted string("A")`}, []string{`test.idl:1:1: \u escapes are only allowed in wide literals`}},
+		{`"a\0b"`, []string{`quoted string("a\x00b")`}, []string{"test.idl:1:1: string literals may not contain a null character"}},
+	})
+}
@@ -0,0 +1,92 @@
+package idl
+
+import (
+	stdcontext "context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+)
+
+// ParseOptions configures lexing and parsing. The zero value is ready to use:
+// nothing is logged, and problems are only returned as errors.
+type ParseOptions struct {
+	// Logger, if set, receives a trace of the lexer and parser's work at
+	// debug level, and every diagnostic as it is found, at a level matching
+	// its severity.
+	Logger *slog.Logger
+
+	// DiagnosticSink, if set, is called with every diagnostic as it is
+	// found. This includes warnings and notes, which are not returned as
+	// errors.
+	DiagnosticSink func(*Diagnostic)
+
+	// FS, if set, is where #include directives find their files, e.g.
+	// os.DirFS(".") or an embed.FS. Without it, includes are not loaded,
+	// and a warning says so.
+	// File names are paths in FS, including the names given to LexFile.
+	FS fs.FS
+
+	// IncludePaths lists the directories in FS that are searched for
+	// included files, in order. #include "file" looks in the including
+	// file's directory first; #include <file> only looks here.
+	IncludePaths []string
+
+	// Defines holds macros to define before preprocessing, as -D does for a
+	// C compiler. Each value is the macro's replacement text, so use "1" for
+	// the equivalent of -DFOO. A function-like macro is given with its
+	// parameters in the name, e.g. "MAX(a, b)".
+	Defines map[string]string
+
+	// Undefines lists macros to leave undefined, as -U does. It takes
+	// precedence over Defines.
+	Undefines []string
+
+	// UnknownAnnotations says how annotations that are neither built into
+	// IDL nor declared with @annotation are reported. By default, they are
+	// warnings.
+	UnknownAnnotations UnknownAnnotationPolicy
+}
+
+// UnknownAnnotationPolicy says how annotations that are not known are
+// reported.
+type UnknownAnnotationPolicy int
+
+const (
+	// UnknownAnnotationWarn reports unknown annotations as warnings.
+	UnknownAnnotationWarn UnknownAnnotationPolicy = iota
+
+	// UnknownAnnotationIgnore does not report unknown annotations.
+	UnknownAnnotationIgnore
+
+	// UnknownAnnotationError reports unknown annotations as errors.
+	UnknownAnnotationError
+)
+
+// Log a debug trace message, if a logger wants it.
+func (o *ParseOptions) debugf(format string, args ...interface{}) {
+	if o.Logger == nil || !o.Logger.Enabled(stdcontext.Background(), slog.LevelDebug) {
+		return
+	}
+	o.Logger.Debug(fmt.Sprintf(format, args...))
+}
+
+// Pass a diagnostic on to the sink and logger, if any.
+func (o *ParseOptions) report(d *Diagnostic) {
+	if o.DiagnosticSink != nil {
+		o.DiagnosticSink(d)
+	}
+	if o.Logger == nil {
+		return
+	}
+
+	level := slog.LevelError
+	switch d.Severity {
+	case SeverityWarning:
+		level = slog.LevelWarn
+	case SeverityNote:
+		level = slog.LevelInfo
+	}
+	o.Logger.Log(stdcontext.Background(), level, d.Msg,
+		slog.String("pos", d.Pos.String()),
+		slog.String("code", string(d.Code)))
+}
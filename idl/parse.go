@@ -2,11 +2,8 @@ package idl
 
 import (
 	"fmt"
-	"strconv"
 )
 
-const parseDebug = false
-
 // A context id is used to drive the internal state machine. It is not needed
 // outside the parser.
 type contextID int32
@@ -25,6 +22,14 @@ func (ctx contextID) String() string {
 		return "interface"
 	case contextUnion:
 		return "union"
+	case contextException:
+		return "exception"
+	case contextBitmask:
+		return "bitmask"
+	case contextBitset:
+		return "bitset"
+	case contextAnnotation:
+		return "annotation"
 	}
 
 	return "(wtf)"
@@ -33,6 +38,18 @@ func (ctx contextID) String() string {
 type context struct {
 	id    contextID
 	value string
+	pos   Position
+
+	// The node being populated in this context, according to id
+	module     *Module
+	strct      *Struct
+	enum       *Enum
+	iface      *Interface
+	union      *Union
+	except     *Exception
+	bitmask    *Bitmask
+	bitset     *Bitset
+	annotation *AnnotationDecl
 }
 
 const (
@@ -53,70 +70,189 @@ const (
 
 	// In a union
 	contextUnion
+
+	// In an exception
+	contextException
+
+	// In a bitmask
+	contextBitmask
+
+	// In a bitset
+	contextBitset
+
+	// In an annotation declaration
+	contextAnnotation
 )
 
 // A parser parses IDL into an AST representation. It consumes a series of lexed
 // tokens.
 type parser struct {
 	tokens       []Token
+	opts         ParseOptions
 	contextStack []context
 	ppos         int
-	errors       []error
+	errors       ErrorList
 	isEOF        bool
 
-	// current module being populated
-	currentModule *Module
-
-	currentEnum   *Enum
-	currentStruct *Struct
-	currentIface  *Interface
-	currentUnion  *Union
+	// set after an error is reported, until the parser has found a
+	// sensible place to carry on from (see synchronize)
+	recovering bool
+
+	// current nodes being populated, from the innermost context of each
+	// kind
+	currentModule     *Module
+	currentEnum       *Enum
+	currentStruct     *Struct
+	currentIface      *Interface
+	currentUnion      *Union
+	currentExcept     *Exception
+	currentBitmask    *Bitmask
+	currentBitset     *Bitset
+	currentAnnotation *AnnotationDecl
 
 	// root module that everything belongs in
 	rootModule *Module
+
+	// how many template parameter lists the parser is in, where >> is not
+	// a shift
+	templateDepth int
+
+	// #pragma keylist directives, in the order they were read
+	keylists []keylist
+
+	// annotations read for the declaration that follows them, and the index
+	// of the first one's token
+	annotations      Annotations
+	annotationsStart int
+}
+
+// Log a debug trace message.
+func (p *parser) debugf(format string, args ...interface{}) {
+	p.opts.debugf(format, args...)
 }
 
-// Report an error during parsing. Further parsing will be aborted.
-func (p *parser) reportError(err error) {
-	// Ignore all errors after EOF, as they are likely bogus (due to our
-	// returning a silly token in that case to avoid crashes).
-	if !p.isEOF {
-		fmt.Printf("Got parse error: %s\n", err)
-		p.errors = append(p.errors, err)
+// Pass a diagnostic on to the options' sink and logger. Errors are also
+// collected, to be returned once parsing is done.
+func (p *parser) report(d *Diagnostic) {
+	p.opts.report(d)
+	if d.Severity == SeverityError {
+		p.errors.Add(d)
+	}
+}
+
+// Report an error during parsing, at the current token. The parser will skip
+// ahead to the end of the declaration it is in, and carry on from there.
+func (p *parser) reportError(code DiagnosticCode, format string, args ...interface{}) {
+	// Errors following the first one in a declaration are usually a
+	// consequence of it, so they are not interesting.
+	if p.recovering {
+		return
+	}
+
+	msg := fmt.Sprintf(format, args...)
+
+	// Likewise, only report running out of tokens once.
+	if p.atEnd() {
+		if p.isEOF {
+			return
+		}
+		p.isEOF = true
+		code = CodeUnexpectedEOF
+		msg = "unexpected EOF: " + msg
+	}
+
+	d := &Diagnostic{
+		Code:     code,
+		Severity: SeverityError,
+		Pos:      p.tok().Pos,
+		End:      p.tok().End,
+		Msg:      msg,
 	}
+	p.report(d)
+	p.recovering = true
 }
 
-// Does the parsing have an error already?
-func (p *parser) hasError() bool {
-	return len(p.errors) != 0
+// Skip tokens until the end of the declaration that had an error: the next
+// semicolon, or the end of a braced block. A closing brace that belongs to an
+// enclosing context is left alone, so that the context is closed normally.
+func (p *parser) synchronize() {
+	depth := 0
+	for !p.atEnd() {
+		switch p.tok().ID {
+		case TokenOpenBrace:
+			depth++
+		case TokenCloseBrace:
+			if depth == 0 {
+				p.recovering = false
+				return
+			}
+			depth--
+		case TokenSemicolon:
+			if depth == 0 {
+				p.advance()
+				p.recovering = false
+				return
+			}
+		}
+		p.advance()
+	}
+	p.recovering = false
 }
 
 // Small helper to read a type name. A type name is a bit "special" since it
 // might be one word ("int"), or multiple ("unsigned int").
 func (p *parser) parseType() Type {
-	t := Type{}
+	t := Type{Pos: p.tok().Pos}
 
-	if p.tok().ID != TokenIdentifier {
-		p.reportError(fmt.Errorf("expected type name"))
+	if p.tok().ID != TokenIdentifier && p.tok().ID != TokenNamespace {
+		p.reportError(CodeUnexpectedToken, "expected type name")
 		return t
 	}
 
-	t.Name = p.tok().Value
-	p.advance()
+	// Foo, Foo::Bar, ::Foo::Bar
+	t.Name = p.parseScopedName()
+	if t.Name == "" {
+		return t
+	}
 
 	// sequence<foo>, string<foo>
-	// ### this should come after the namespace check
 	if p.tok().ID == TokenLessThan {
 		p.advance()
-		t.TemplateParameters = append(t.TemplateParameters, p.parseType())
+		p.templateDepth++
+		defer func() { p.templateDepth-- }()
+
+		if t.Name == "string" || t.Name == "wstring" {
+			// string<10>
+			t.Bound = p.parseBound()
+		} else if t.Name == "fixed" {
+			// fixed<10, 2>
+			t.Bound = p.parseBound()
+			if p.tok().ID != TokenComma {
+				p.reportError(CodeUnexpectedToken, "expected: ,")
+				return t
+			}
+			p.advance()
+			t.Scale = p.parseBound()
+		} else {
+			t.TemplateParameters = append(t.TemplateParameters, p.parseType())
+		}
 
 		for p.tok().ID == TokenComma {
 			p.advance()
+			if t.Name == "sequence" || (t.Name == "map" && len(t.TemplateParameters) == 2) {
+				// sequence<long, 10>, map<string, long, 10>
+				t.Bound = p.parseBound()
+				break
+			}
 			t.TemplateParameters = append(t.TemplateParameters, p.parseType())
 		}
 
 		if p.tok().ID != TokenGreaterThan {
-			fmt.Errorf("expected: >")
+			p.reportError(CodeUnexpectedToken, "expected: >")
+			return t
+		}
+		if t.Name == "map" && len(t.TemplateParameters) != 2 {
+			p.reportError(CodeUnexpectedToken, "expected key and value types in map")
 			return t
 		}
 
@@ -125,131 +261,129 @@ func (p *parser) parseType() Type {
 
 	if t.Name == "unsigned" {
 		// consume an additional word
-		if p.tok().ID != TokenIdentifier {
-			p.reportError(fmt.Errorf("expected numeric type"))
+		if p.tok().ID != TokenIdentifier || (p.tok().Value != "short" && p.tok().Value != "long") {
+			p.reportError(CodeUnexpectedToken, "expected numeric type")
 			return t
 		}
 
 		t.Name += " " + p.tok().Value
 		p.advance()
+
+		// "unsigned long long"
+		if t.Name == "unsigned long" && p.tok().ID == TokenIdentifier && p.tok().Value == "long" {
+			t.Name += " " + p.tok().Value
+			p.advance()
+		}
 	} else if t.Name == "long" {
-		// "long long"
-		if p.tok().ID == TokenIdentifier && p.tok().Value == "long" {
+		// "long long", "long double"
+		if p.tok().ID == TokenIdentifier && (p.tok().Value == "long" || p.tok().Value == "double") {
 			t.Name += " " + p.tok().Value
 			p.advance()
 		}
 	}
 
-	if p.tok().ID == TokenNamespace {
-		// Foo::Bar
-		p.advance()
-		t.Name += "::" + p.tok().Value
-		p.advance()
+	t.Primitive = primitiveKinds[t.Name]
+	switch {
+	case t.Primitive != PrimitiveNone:
+		t.Kind = TypePrimitive
+	case t.Name == "sequence" && len(t.TemplateParameters) == 1:
+		t.Kind = TypeSequence
+	case t.Name == "map" && len(t.TemplateParameters) == 2:
+		t.Kind = TypeMap
 	}
+	return t
+}
 
-	ahead := 1
-	if p.peekTok(1).ID == TokenIdentifier {
-		// ### namespaces or not?
-		ahead += 1
+// Read a size given by a constant expression, as in string<10> or
+// long foo[SIZE]. Returns nil on error.
+func (p *parser) parseBound() *Bound {
+	e := p.parseConstExpr()
+	if e == nil {
+		return nil
 	}
+	return &Bound{Expr: e}
+}
 
-	atok := p.peekTok(ahead)
+// Add a forward declaration of a type to the current module.
+// struct Foo;
+func (p *parser) parseForwardDecl(kind ForwardKind, start int, name string, pos Position) {
+	p.debugf("Read forward declaration of %s %s", kind, name)
+	doc := p.comments(start, p.ppos)
+	p.advance()
+	d := p.declarations()
+	d.ForwardDecls = append(d.ForwardDecls, ForwardDecl{
+		Kind: kind,
+		Name: name,
+		Pos:  pos,
+		Doc:  doc,
+	})
+}
 
-	if atok.ID == TokenOpenSquareBracket {
-		// value[3]
-		atok = p.peekTok(ahead + 1)
+// Read the name of a member, typedef, etc, and any array dimensions that
+// follow it, e.g. "data[MAX_LEN][2]". The dimensions are added to t.
+func (p *parser) parseDeclarator(t Type) (string, Type) {
+	name := p.parseIdentifier()
 
-		if atok.ID != TokenIdentifier {
-			p.reportError(fmt.Errorf("expected quantity"))
-			return t
+	for p.tok().ID == TokenOpenSquareBracket {
+		p.advance()
+		dim := p.parseBound()
+		if dim == nil {
+			return name, t
 		}
 
-		fmt.Printf("Parse %s\n", atok.Value)
-		q, err := strconv.Atoi(atok.Value)
-		if err != nil {
-			// ### allow constants?
-			p.reportError(fmt.Errorf("expected number: %s", err))
-			return t
+		if p.tok().ID != TokenCloseSquareBracket {
+			p.reportError(CodeUnexpectedToken, "expected close bracket")
+			return name, t
 		}
+		p.advance()
 
-		t.Quantity = &q
-		atok = p.peekTok(ahead + 2)
-
-		if atok.ID != TokenCloseSquareBracket {
-			p.reportError(fmt.Errorf("expected close bracket"))
-			return t
-		}
+		t.Dimensions = append(t.Dimensions, *dim)
 	}
 
-	return t
+	return name, t
 }
 
-func (p *parser) parseIdentifier() string {
-	if p.tok().ID != TokenIdentifier {
-		p.reportError(fmt.Errorf("expected identifier"))
-		return ""
-	}
-
-	identifierName := p.tok().Value
-	p.advance()
-
-	if p.tok().ID == TokenNamespace {
-		// Foo::Bar
-		p.advance()
+// Read a comma separated list of declarators, e.g. "x, y[3], z", which all
+// share the type t.
+func (p *parser) parseDeclarators(t Type) []Member {
+	members := []Member{}
+	for {
 		if p.tok().ID != TokenIdentifier {
-			p.reportError(fmt.Errorf("expected type name in namespace"))
-			return ""
+			p.reportError(CodeUnexpectedToken, "expected name")
+			return members
 		}
 
-		identifierName += "::" + p.tok().Value
-		p.advance()
-	}
+		m := Member{Pos: p.tok().Pos}
+		m.Name, m.Type = p.parseDeclarator(t)
+		members = append(members, m)
 
-	if p.tok().ID == TokenOpenSquareBracket {
-		// parseType() already validated, and retrieved the info.
-		for p.tok().ID != TokenCloseSquareBracket {
-			p.advance()
+		if p.tok().ID != TokenComma {
+			return members
 		}
-
-		p.advance() // eat bracket too
+		p.advance()
 	}
-
-	return identifierName
 }
 
-func (p *parser) parseValue() string {
-	if p.tok().ID != TokenIdentifier &&
-		p.tok().ID != TokenLessThan &&
-		p.tok().ID != TokenStringLiteral {
-		p.reportError(fmt.Errorf("expected value"))
+// Read the plain name of a declaration. Names that refer to other
+// declarations, which may be scoped, are read by parseScopedName instead.
+func (p *parser) parseIdentifier() string {
+	if p.tok().ID != TokenIdentifier {
+		p.reportError(CodeUnexpectedToken, "expected identifier")
 		return ""
 	}
 
-	val := ""
-	isString := false
-	if p.tok().ID == TokenStringLiteral {
-		isString = true
-		val = "\""
-	}
-
-	for p.tok().ID == TokenIdentifier ||
-		p.tok().ID == TokenLessThan ||
-		p.tok().ID == TokenStringLiteral {
-		val += p.tok().Value
-		p.advance()
-	}
-
-	if isString {
-		val += "\""
-	}
-
-	return val
+	identifierName := p.tok().Value
+	p.advance()
+	return identifierName
 }
 
 // Parse a regular word. It might be a keyword (like 'struct' or 'module', or it
 // might be a type name (in struct or interface members).
 func (p *parser) parseTokenWord() {
 	word := p.tok().Value
+	if p.tok().ID == TokenNamespace {
+		word = p.tok().ID.symbol()
+	}
 
 	switch p.currentContext().id {
 	case contextGlobal:
@@ -259,64 +393,140 @@ func (p *parser) parseTokenWord() {
 		switch word {
 		case keywordModule:
 			p.parseModule()
-		case keywordTypedef:
-			p.parseTypedef()
-		case keywordStruct:
-			p.parseStruct()
-		case keywordConst:
-			p.parseConst()
-		case keywordEnum:
-			p.parseEnum()
 		case keywordInterface:
 			p.parseInterface()
-		case keywordUnion:
-			p.parseUnion()
 		default:
-			p.reportError(fmt.Errorf("unexpected keyword in global/module context: %s", word))
+			if !p.parseDeclaration(word) {
+				p.reportError(CodeUnexpectedKeyword, "unexpected keyword in global/module context: %s", word)
+			}
 			return
 		}
 
 	// For other contexts, they are supposed to validate the contents
-	// themselves.
+	// themselves, apart from nested declarations.
 	case contextStruct:
-		p.parseStructMember()
+		if !p.parseDeclaration(word) {
+			p.parseStructMember()
+		}
 	case contextEnum:
 		p.parseEnumMember()
 	case contextInterface:
-		p.parseInterfaceMember()
+		if !p.parseDeclaration(word) {
+			p.parseInterfaceMember()
+		}
 	case contextUnion:
-		p.parseUnionMember()
+		if !p.parseDeclaration(word) {
+			p.parseUnionMember()
+		}
+	case contextException:
+		p.parseExceptionMember()
+	case contextBitmask:
+		p.parseBitmaskMember()
+	case contextBitset:
+		p.parseBitsetMember()
+	case contextAnnotation:
+		p.parseAnnotationMember()
 	default:
 		panic("unhandled context")
 	}
 }
 
+// Parse a type or constant declaration, which may be in a module, or nested
+// inside an interface, struct or union. Returns false if the word does not
+// start one.
+func (p *parser) parseDeclaration(word string) bool {
+	switch word {
+	case keywordTypedef:
+		p.parseTypedef()
+	case keywordStruct:
+		p.parseStruct()
+	case keywordConst:
+		p.parseConst()
+	case keywordEnum:
+		p.parseEnum()
+	case keywordUnion:
+		p.parseUnion()
+	case keywordException:
+		p.parseException()
+	case keywordBitmask:
+		p.parseBitmask()
+	case keywordBitset:
+		p.parseBitset()
+	default:
+		return false
+	}
+	return true
+}
+
+// Return where declarations in the current context are added: the innermost
+// interface, struct, union or module.
+func (p *parser) declarations() *Declarations {
+	switch cctx := p.currentContext(); cctx.id {
+	case contextStruct:
+		return &cctx.strct.Declarations
+	case contextInterface:
+		return &cctx.iface.Declarations
+	case contextUnion:
+		return &cctx.union.Declarations
+	}
+	return &p.currentModule.Declarations
+}
+
 // Is the parser at the end of the token stream?
 func (p *parser) atEnd() bool {
-	// ### right?
-	return p.ppos >= len(p.tokens)-1
+	return p.ppos >= len(p.tokens)
 }
 
-// Return the next token for parsing
+// Return the token the given number of tokens ahead of the current one,
+// ignoring newlines and comments.
 func (p *parser) peekTok(ahead int) Token {
-	if p.atEnd() || p.ppos+ahead >= len(p.tokens)-ahead {
-		if parseDebug {
-			fmt.Printf("Peeking ahead invalid!\n")
+	i := p.ppos
+	for ahead > 0 && i < len(p.tokens) {
+		i++
+		if i < len(p.tokens) && !isSkippedToken(p.tokens[i].ID) {
+			ahead--
 		}
-		return Token{TokenInvalid, ""}
 	}
-	if parseDebug {
-		fmt.Printf("Peeking ahead ppos %d is %s\n", p.ppos, p.tokens[p.ppos+1])
+	if i >= len(p.tokens) {
+		p.debugf("Peeking ahead invalid!")
+		return p.invalidToken()
+	}
+	p.debugf("Peeking ahead ppos %d is %s", p.ppos, p.tokens[i])
+	return p.tokens[i]
+}
+
+// Return the index of the last token that was parsed, ignoring newlines and
+// comments.
+func (p *parser) lastTokenIndex() int {
+	i := p.ppos - 1
+	for i > 0 && isSkippedToken(p.tokens[i].ID) {
+		i--
+	}
+	return i
+}
+
+// Tokens that the parser steps over, rather than parsing.
+func isSkippedToken(id TokenID) bool {
+	return id == TokenEndLine || id == TokenComment
+}
+
+// Return the token used in place of a real one past the end of the stream.
+// It is positioned at the last token, so that errors still point somewhere
+// sensible.
+func (p *parser) invalidToken() Token {
+	tok := Token{ID: TokenInvalid}
+	if len(p.tokens) > 0 {
+		tok.Pos = p.tokens[len(p.tokens)-1].Pos
+		tok.End = p.tokens[len(p.tokens)-1].End
 	}
-	return p.tokens[p.ppos+ahead]
+	return tok
 }
 
-// Return the current token under parsing
+// Return the current token under parsing. Past the end of the stream, this is
+// a TokenInvalid.
 func (p *parser) tok() Token {
 	if p.atEnd() {
-		p.isEOF = true
-		p.reportError(fmt.Errorf("unexpected EOF"))
-		return Token{TokenInvalid, ""}
+		return p.invalidToken()
 	}
 	return p.tokens[p.ppos]
 }
@@ -327,120 +537,255 @@ func (p *parser) advance() {
 		p.advanceAndDontSkipNewLines()
 
 		// Skip all whitespace tokens.
-		if p.tok().ID != TokenEndLine {
+		if p.atEnd() || p.tok().ID != TokenEndLine {
 			break
 		}
 	}
 }
 
-// Advance the parse stream one position
+// Advance the parse stream one position, skipping comments, but stopping on
+// newlines.
 func (p *parser) advanceAndDontSkipNewLines() {
-	if parseDebug {
-		fmt.Printf("Advancing, ppos was %d/%d, old token %s new token %s\n", p.ppos, len(p.tokens), p.tokens[p.ppos], p.tokens[p.ppos+1])
+	p.debugf("Advancing, ppos was %d/%d, old token %s new token %s", p.ppos, len(p.tokens), p.tok(), p.peekTok(1))
+	for !p.atEnd() {
+		p.ppos++
+		if p.atEnd() || p.tok().ID != TokenComment {
+			break
+		}
 	}
-	p.ppos++
 }
 
 // Parse a series of tokens, and return an AST representing the IDL's content.
+// The tokens are preprocessed first, as Preprocess does.
+//
+// Parsing does not stop at the first error. Instead, the parser skips to the
+// end of the broken declaration and carries on, so that all errors are
+// returned at once as an ErrorList. The module built from the declarations
+// that could be parsed is returned in either case.
 func Parse(toks []Token) (Module, error) {
+	return ParseWithOptions(toks, ParseOptions{})
+}
+
+// ParseWithOptions is like Parse, but allows configuring logging and
+// diagnostics.
+func ParseWithOptions(toks []Token, opts ParseOptions) (Module, error) {
+	root := &Module{}
+	if len(toks) > 0 {
+		root.Pos = Position{
+			Filename: toks[0].Pos.Filename,
+			Line:     1,
+			Column:   1,
+		}
+	}
+
+	toks, errs := preprocess(toks, opts)
 	p := &parser{
 		tokens:        toks,
+		opts:          opts,
+		errors:        errs,
 		isEOF:         false,
-		currentModule: &Module{},
+		currentModule: root,
 	}
 	p.rootModule = p.currentModule
-	p.pushContext(contextGlobal, "")
+	p.pushContext(contextGlobal, "", Position{})
 
-	for !p.atEnd() && !p.hasError() {
+	for !p.atEnd() {
 		tok := p.tok()
-		if parseDebug {
-			if len(tok.Value) > 0 {
-				fmt.Printf("ppos %d Parsing token %s\n", p.ppos, tok)
-			}
+		ppos := p.ppos
+		errs := len(p.errors)
+		if len(tok.Value) > 0 {
+			p.debugf("ppos %d Parsing token %s", p.ppos, tok)
 		}
 
 		switch tok.ID {
 		case TokenHash:
 			p.parseTokenHash()
-		case TokenIdentifier:
+			if p.recovering {
+				// A directive ends with its line, so the declaration on
+				// the next one is not part of the error.
+				p.skipLine()
+				p.recovering = false
+				continue
+			}
+		case TokenAt:
+			p.parseAnnotation()
+		case TokenIdentifier, TokenNamespace:
+			// A member may start with a scoped type name, as in
+			// "::Mod::Foo x;".
 			p.parseTokenWord()
+			if len(p.errors) == errs && !p.recovering {
+				p.dropAnnotations()
+			}
 		case TokenCloseBrace:
+			p.dropAnnotations()
+			if p.currentContext().id == contextGlobal {
+				p.reportError(CodeUnexpectedToken, "unexpected close brace")
+
+				// There is nothing for it to close, so step over it. The
+				// brace is where synchronize would stop, so don't.
+				p.advance()
+				p.recovering = false
+				continue
+			}
 			p.popContext()
 			p.advance()
 		default:
+			p.dropAnnotations()
 			p.advance()
 		}
-	}
 
-	p.popContext()
-	if p.hasError() {
-		return Module{}, p.errors[0]
+		if len(p.errors) > errs || p.recovering {
+			// Any annotations were for the declaration being skipped.
+			p.takeAnnotations()
+			p.synchronize()
+		} else if p.ppos == ppos {
+			// Don't get stuck on a token nobody wanted.
+			p.advance()
+		}
 	}
 
-	if len(p.contextStack) > 0 {
-		panic("too many contexts")
+	p.dropAnnotations()
+
+	for p.currentContext().id != contextGlobal {
+		cctx := p.currentContext()
+		p.report(&Diagnostic{
+			Code:     CodeUnterminatedScope,
+			Severity: SeverityError,
+			Pos:      p.invalidToken().Pos,
+			Msg:      fmt.Sprintf("unexpected EOF: unterminated %s %s", cctx.id, cctx.value),
+			Related: []RelatedInformation{{
+				Pos: cctx.pos,
+				Msg: fmt.Sprintf("%s %s opened here", cctx.id, cctx.value),
+			}},
+		})
+		p.popContext()
 	}
-	return *p.rootModule, nil
+	p.popContext()
+
+	p.resolve()
+
+	return *p.rootModule, p.errors.Err()
 }
 
-func (p *parser) pushContext(ctx contextID, val string) {
-	if parseDebug {
-		fmt.Printf("Opened context: %s (%s)\n", ctx, val)
-	}
+func (p *parser) pushContext(ctx contextID, val string, pos Position) {
+	p.debugf("Opened context: %s (%s)", ctx, val)
 
+	c := context{id: ctx, value: val, pos: pos}
 	switch ctx {
+	case contextGlobal:
+		c.module = p.rootModule
 	case contextUnion:
-		e := Union{Name: val}
-		p.currentModule.Unions = append(p.currentModule.Unions, e)
-		p.currentUnion = &p.currentModule.Unions[len(p.currentModule.Unions)-1]
+		d := p.declarations()
+		d.Unions = append(d.Unions, Union{Name: val, Pos: pos})
+		c.union = &d.Unions[len(d.Unions)-1]
 	case contextInterface:
-		e := Interface{Name: val}
-		p.currentModule.Interfaces = append(p.currentModule.Interfaces, e)
-		p.currentIface = &p.currentModule.Interfaces[len(p.currentModule.Interfaces)-1]
+		p.currentModule.Interfaces = append(p.currentModule.Interfaces, Interface{Name: val, Pos: pos})
+		c.iface = &p.currentModule.Interfaces[len(p.currentModule.Interfaces)-1]
 	case contextStruct:
-		e := Struct{Name: val}
-		p.currentModule.Structs = append(p.currentModule.Structs, e)
-		p.currentStruct = &p.currentModule.Structs[len(p.currentModule.Structs)-1]
+		d := p.declarations()
+		d.Structs = append(d.Structs, Struct{Name: val, Pos: pos})
+		c.strct = &d.Structs[len(d.Structs)-1]
 	case contextEnum:
-		e := Enum{Name: val}
-		p.currentModule.Enums = append(p.currentModule.Enums, e)
-		p.currentEnum = &p.currentModule.Enums[len(p.currentModule.Enums)-1]
+		d := p.declarations()
+		d.Enums = append(d.Enums, Enum{Name: val, Pos: pos})
+		c.enum = &d.Enums[len(d.Enums)-1]
+	case contextException:
+		d := p.declarations()
+		d.Exceptions = append(d.Exceptions, Exception{Name: val, Pos: pos})
+		c.except = &d.Exceptions[len(d.Exceptions)-1]
+	case contextBitmask:
+		d := p.declarations()
+		d.Bitmasks = append(d.Bitmasks, Bitmask{Name: val, Pos: pos})
+		c.bitmask = &d.Bitmasks[len(d.Bitmasks)-1]
+	case contextBitset:
+		d := p.declarations()
+		d.Bitsets = append(d.Bitsets, Bitset{Name: val, Pos: pos})
+		c.bitset = &d.Bitsets[len(d.Bitsets)-1]
+	case contextAnnotation:
+		d := p.declarations()
+		d.AnnotationDecls = append(d.AnnotationDecls, AnnotationDecl{Name: val, Pos: pos})
+		c.annotation = &d.AnnotationDecls[len(d.AnnotationDecls)-1]
 	case contextModule:
 		m := Module{
 			Name:   val,
+			Pos:    pos,
 			Parent: p.currentModule,
 		}
 		p.currentModule.Modules = append(p.currentModule.Modules, m)
-		p.currentModule = &p.currentModule.Modules[len(p.currentModule.Modules)-1]
+		c.module = &p.currentModule.Modules[len(p.currentModule.Modules)-1]
 	}
 
-	p.contextStack = append(p.contextStack, context{ctx, val})
+	p.contextStack = append(p.contextStack, c)
+	p.updateCurrent()
 }
 
 func (p *parser) popContext() {
 	cctx := p.currentContext()
+	p.debugf("Closed context: %s (%s)", cctx.id, cctx.value)
+	p.contextStack = p.contextStack[:len(p.contextStack)-1]
+	p.updateCurrent()
+}
 
-	switch cctx.id {
-	case contextUnion:
-		p.currentUnion = nil
-	case contextInterface:
-		p.currentIface = nil
-	case contextStruct:
-		p.currentStruct = nil
-	case contextEnum:
-		p.currentEnum = nil
-	case contextModule:
-		if p.currentModule.Parent != nil {
-			p.currentModule = p.currentModule.Parent
+// Point the current nodes at those of the innermost contexts. Only the
+// innermost context's node gets new declarations added, so the pointers stay
+// valid until it is closed.
+func (p *parser) updateCurrent() {
+	p.currentEnum = nil
+	p.currentStruct = nil
+	p.currentIface = nil
+	p.currentUnion = nil
+	p.currentExcept = nil
+	p.currentBitmask = nil
+	p.currentBitset = nil
+	p.currentAnnotation = nil
+	for i := len(p.contextStack) - 1; i >= 0; i-- {
+		c := p.contextStack[i]
+		if c.module != nil {
+			p.currentModule = c.module
+			return
+		}
+		if p.currentEnum == nil {
+			p.currentEnum = c.enum
+		}
+		if p.currentStruct == nil {
+			p.currentStruct = c.strct
+		}
+		if p.currentIface == nil {
+			p.currentIface = c.iface
+		}
+		if p.currentUnion == nil {
+			p.currentUnion = c.union
+		}
+		if p.currentExcept == nil {
+			p.currentExcept = c.except
+		}
+		if p.currentBitmask == nil {
+			p.currentBitmask = c.bitmask
+		}
+		if p.currentBitset == nil {
+			p.currentBitset = c.bitset
+		}
+		if p.currentAnnotation == nil {
+			p.currentAnnotation = c.annotation
 		}
 	}
-
-	if parseDebug {
-		fmt.Printf("Closed context: %s (%s)\n", cctx.id, cctx.value)
-	}
-	p.contextStack = p.contextStack[:len(p.contextStack)-1]
 }
 
 func (p *parser) currentContext() context {
 	return p.contextStack[len(p.contextStack)-1]
 }
+
+// Return the scoped name of the innermost context, e.g. "Mod::Struct".
+func (p *parser) scopeName() string {
+	name := ""
+	for _, c := range p.contextStack {
+		if c.id == contextGlobal {
+			continue
+		}
+		if name != "" {
+			name += "::"
+		}
+		name += c.value
+	}
+	return name
+}
@@ -0,0 +1,186 @@
+package idl
+
+// Read an annotation, and keep it until the declaration it applies to is
+// parsed.
+// @name, @name(value) or @name(param = value, ...)
+func (p *parser) parseAnnotation() {
+	start := p.ppos
+	pos := p.tok().Pos
+	p.advance() // skip @
+
+	if p.tok().ID != TokenIdentifier && p.tok().ID != TokenNamespace {
+		p.reportError(CodeUnexpectedToken, "expected annotation name")
+		return
+	}
+
+	a := Annotation{Name: p.parseScopedName(), Pos: pos}
+	if a.Name == "" {
+		return
+	}
+
+	if a.Name == keywordAnnotation && p.tok().ID == TokenIdentifier {
+		p.parseAnnotationDecl(start)
+		return
+	}
+
+	if p.tok().ID == TokenOpenBracket {
+		p.advance()
+
+		for p.tok().ID != TokenCloseBracket {
+			if p.tok().ID == TokenIdentifier && p.peekTok(1).ID == TokenEquals {
+				// @range(min = 0, max = 10)
+				param := AnnotationParam{Name: p.tok().Value, Pos: p.tok().Pos}
+				p.advance()
+				p.advance()
+				if param.Expr = p.parseConstExpr(); param.Expr == nil {
+					return
+				}
+				a.NamedParams = append(a.NamedParams, param)
+			} else {
+				// @id(5)
+				param := p.parseConstExpr()
+				if param == nil {
+					return
+				}
+				a.Params = append(a.Params, param)
+			}
+
+			if p.tok().ID == TokenComma {
+				p.advance()
+			} else if p.tok().ID != TokenCloseBracket {
+				p.reportError(CodeUnexpectedToken, "expected: )")
+				return
+			}
+		}
+		p.advance()
+
+		if len(a.Params) > 0 && len(a.NamedParams) > 0 {
+			p.report(&Diagnostic{
+				Code:     CodeUnexpectedToken,
+				Severity: SeverityError,
+				Pos:      pos,
+				Msg:      "annotation @" + a.Name + " mixes parameters given by name and by position",
+			})
+		}
+	}
+
+	p.debugf("Read annotation: %s", a)
+	if len(p.annotations) == 0 {
+		p.annotationsStart = start
+	}
+	p.annotations = append(p.annotations, a)
+}
+
+// Read any annotations at the current token, for declarations that are parsed
+// in one go rather than from the main loop, such as union members.
+func (p *parser) parseAnnotations() {
+	for p.tok().ID == TokenAt && !p.recovering {
+		p.parseAnnotation()
+	}
+}
+
+// Return the index of the first token of the declaration at the current
+// token, including any annotations before it.
+func (p *parser) declStart() int {
+	if len(p.annotations) > 0 {
+		return p.annotationsStart
+	}
+	return p.ppos
+}
+
+// Return the annotations read since the last declaration, and forget them.
+func (p *parser) takeAnnotations() Annotations {
+	anns := p.annotations
+	p.annotations = nil
+	return anns
+}
+
+// Forget the annotations read since the last declaration, warning that they
+// apply to nothing, as in "@key;" or an annotation just before a }.
+func (p *parser) dropAnnotations() {
+	for _, a := range p.takeAnnotations() {
+		p.report(&Diagnostic{
+			Code:     CodeDanglingAnnotation,
+			Severity: SeverityWarning,
+			Pos:      a.Pos,
+			Msg:      "annotation @" + a.Name + " is not followed by a declaration",
+		})
+	}
+}
+
+// Find the value of an annotation with one parameter, e.g. @value(5) or
+// @value(value = 5). Returns nil if the annotation is not there, or is
+// missing its parameter.
+func (p *parser) annotationParam(anns Annotations, name string) Expr {
+	a, ok := anns.Get(name)
+	if !ok {
+		return nil
+	}
+	e := a.Param("value")
+	if e == nil || len(a.Params)+len(a.NamedParams) != 1 {
+		p.report(&Diagnostic{
+			Code:     CodeUnexpectedToken,
+			Severity: SeverityError,
+			Pos:      a.Pos,
+			Msg:      "@" + name + " takes one parameter",
+		})
+		return nil
+	}
+	return e
+}
+
+// Handle the start of an annotation declaration, from after "@annotation".
+// @annotation MyAnno {
+func (p *parser) parseAnnotationDecl(start int) {
+	if len(p.annotations) > 0 {
+		start = p.annotationsStart
+		p.debugf("Ignoring %d annotations", len(p.takeAnnotations()))
+	}
+
+	annotationPos := p.tok().Pos
+	annotationName := p.parseIdentifier()
+
+	if p.tok().ID != TokenOpenBrace {
+		p.reportError(CodeUnexpectedToken, "expected annotation contents")
+		return
+	}
+
+	brace := p.ppos
+	p.advance()
+	p.debugf("Read annotation declaration %s", annotationName)
+	p.pushContext(contextAnnotation, annotationName, annotationPos)
+	p.currentAnnotation.Doc = p.comments(start, brace)
+}
+
+// Handle a parameter inside an annotation declaration
+// long level default 1;
+// string tag;
+func (p *parser) parseAnnotationMember() {
+	start := p.ppos
+	memberType := p.parseType()
+
+	if p.tok().ID != TokenIdentifier {
+		p.reportError(CodeUnexpectedToken, "expected annotation member name")
+		return
+	}
+
+	m := AnnotationMember{Pos: p.tok().Pos, Type: memberType}
+	m.Name = p.parseIdentifier()
+
+	if p.tok().ID == TokenIdentifier && p.tok().Value == keywordDefault {
+		p.advance()
+		if m.Default = p.parseConstExpr(); m.Default == nil {
+			return
+		}
+	}
+
+	if p.tok().ID != TokenSemicolon {
+		p.reportError(CodeUnexpectedToken, "expected semicolon")
+		return
+	}
+
+	m.Doc = p.comments(start, p.ppos)
+	p.advance()
+	p.debugf("Read annotation member: %s of type %s", m.Name, m.Type)
+	p.currentAnnotation.Members = append(p.currentAnnotation.Members, m)
+}
@@ -0,0 +1,67 @@
+package idl
+
+// Handle the start of a bitmask
+// bitmask Perms {
+func (p *parser) parseBitmask() {
+	start := p.declStart()
+	anns := p.takeAnnotations()
+	p.advance()
+
+	if p.tok().ID != TokenIdentifier {
+		p.reportError(CodeUnexpectedToken, "expected bitmask name")
+		return
+	}
+
+	bitmaskPos := p.tok().Pos
+	bitmaskName := p.parseIdentifier()
+
+	if p.tok().ID != TokenOpenBrace {
+		p.reportError(CodeUnexpectedToken, "expected bitmask contents")
+		return
+	}
+
+	brace := p.ppos
+	p.advance()
+	p.pushContext(contextBitmask, bitmaskName, bitmaskPos)
+	p.currentBitmask.Doc = p.comments(start, brace)
+	p.currentBitmask.Annotations = anns
+	if bitBound := p.annotationParam(anns, "bit_bound"); bitBound != nil {
+		p.currentBitmask.BitBound = &Bound{Expr: bitBound}
+	}
+}
+
+// Handle a flag in a bitmask
+// READ,
+// @position(3) WRITE,
+func (p *parser) parseBitmaskMember() {
+	if p.tok().ID != TokenIdentifier {
+		p.reportError(CodeUnexpectedToken, "expected bitmask value")
+		return
+	}
+
+	valueName := p.tok().Value
+	valuePos := p.tok().Pos
+	start := p.declStart()
+	end := p.ppos
+	p.advance()
+
+	anns := p.takeAnnotations()
+	position := p.annotationParam(anns, "position")
+
+	if p.tok().ID == TokenComma {
+		end = p.ppos
+	}
+	for p.tok().ID == TokenComma {
+		// eat the comma(s)
+		p.advance()
+	}
+
+	p.debugf("Read bitmask value: %s", valueName)
+	p.currentBitmask.Members = append(p.currentBitmask.Members, BitValue{
+		Name:        valueName,
+		Pos:         valuePos,
+		Doc:         p.comments(start, end),
+		Annotations: anns,
+		Expr:        position,
+	})
+}
@@ -0,0 +1,112 @@
+package idl
+
+// Handle the start of a bitset
+// bitset Flags {
+// bitset MoreFlags : Flags {
+func (p *parser) parseBitset() {
+	start := p.declStart()
+	anns := p.takeAnnotations()
+	p.advance()
+
+	if p.tok().ID != TokenIdentifier {
+		p.reportError(CodeUnexpectedToken, "expected bitset name")
+		return
+	}
+
+	bitsetPos := p.tok().Pos
+	bitsetName := p.parseIdentifier()
+
+	inherits := ""
+	if p.tok().ID == TokenColon {
+		p.advance()
+		if inherits = p.parseScopedName(); inherits == "" {
+			return
+		}
+	}
+
+	if p.tok().ID != TokenOpenBrace {
+		p.reportError(CodeUnexpectedToken, "expected bitset contents")
+		return
+	}
+
+	brace := p.ppos
+	p.advance()
+	p.pushContext(contextBitset, bitsetName, bitsetPos)
+	p.currentBitset.Inherits = inherits
+	p.currentBitset.Doc = p.comments(start, brace)
+	p.currentBitset.Annotations = anns
+}
+
+// Handle fields inside a bitset
+// bitfield<3> a;
+// bitfield<5, octet> b, c;
+// bitfield<2>;
+func (p *parser) parseBitsetMember() {
+	start := p.declStart()
+	anns := p.takeAnnotations()
+	keywordPos := p.tok().Pos
+
+	if p.tok().Value != keywordBitfield {
+		p.reportError(CodeUnexpectedToken, "expected bitfield")
+		return
+	}
+	p.advance()
+
+	if p.tok().ID != TokenLessThan {
+		p.reportError(CodeUnexpectedToken, "expected: <")
+		return
+	}
+	p.advance()
+	p.templateDepth++
+	defer func() { p.templateDepth-- }()
+
+	field := BitField{}
+	width := p.parseBound()
+	if width == nil {
+		return
+	}
+	field.Width = *width
+
+	if p.tok().ID == TokenComma {
+		p.advance()
+		field.Type = p.parseType()
+	}
+
+	if p.tok().ID != TokenGreaterThan {
+		p.reportError(CodeUnexpectedToken, "expected: >")
+		return
+	}
+	p.advance()
+
+	fields := []BitField{}
+	for p.tok().ID == TokenIdentifier {
+		field.Pos = p.tok().Pos
+		field.Name = p.parseIdentifier()
+		fields = append(fields, field)
+
+		if p.tok().ID != TokenComma {
+			break
+		}
+		p.advance()
+	}
+
+	if p.tok().ID != TokenSemicolon {
+		p.reportError(CodeUnexpectedToken, "expected semicolon")
+		return
+	}
+
+	if len(fields) == 0 {
+		// Unused bits have no name.
+		field.Pos = keywordPos
+		fields = append(fields, field)
+	}
+
+	doc := p.comments(start, p.ppos)
+	p.advance()
+	for _, f := range fields {
+		p.debugf("Read bitfield: %s of width %s", f.Name, f.Width.Expr)
+		f.Doc = doc
+		f.Annotations = anns
+		p.currentBitset.Fields = append(p.currentBitset.Fields, f)
+	}
+}
@@ -0,0 +1,118 @@
+package idl
+
+import "strings"
+
+// Collect the comments attached to a declaration: those directly above the
+// token at index start, and those after the token at index end on the same
+// line.
+func (p *parser) comments(start int, end int) Comments {
+	return Comments{
+		Leading:  p.leadingComments(start),
+		Trailing: p.trailingComments(end),
+	}
+}
+
+// Collect the comments on the lines directly above the token at index i. A
+// blank line ends the comments, as does a comment that follows some other
+// token on its line (as that comment belongs to the other token).
+func (p *parser) leadingComments(i int) []Comment {
+	comments := []Comment{}
+	newlines := 0
+	for j := i - 1; j >= 0 && j < len(p.tokens); j-- {
+		tok := p.tokens[j]
+		if tok.ID == TokenEndLine {
+			newlines++
+			if newlines > 1 {
+				break
+			}
+			continue
+		}
+		if tok.ID != TokenComment {
+			break
+		}
+		if j > 0 && !isSkippedToken(p.tokens[j-1].ID) {
+			break
+		}
+		comments = append([]Comment{{Text: tok.Value, Pos: tok.Pos}}, comments...)
+		newlines = 0
+	}
+	if len(comments) == 0 {
+		return nil
+	}
+	return comments
+}
+
+// Collect the comments following the token at index i, up to the end of the
+// line.
+func (p *parser) trailingComments(i int) []Comment {
+	comments := []Comment{}
+	for j := i + 1; j > 0 && j < len(p.tokens); j++ {
+		tok := p.tokens[j]
+		if tok.ID != TokenComment {
+			break
+		}
+		comments = append(comments, Comment{Text: tok.Value, Pos: tok.Pos})
+	}
+	if len(comments) == 0 {
+		return nil
+	}
+	return comments
+}
+
+// Turn the annotations written in comments by older IDL into real ones, e.g.
+// "//@key" into @key and "//@ID 1" into @id(1). Annotations already in anns
+// are not added again.
+func (p *parser) commentAnnotations(comments []Comment, anns Annotations) Annotations {
+	var out Annotations
+	for _, c := range comments {
+		if !strings.HasPrefix(c.Text, "//@") {
+			continue
+		}
+		text := c.Text[len("//@"):]
+		name, rest := text, ""
+		if i := strings.IndexAny(text, " \t"); i >= 0 {
+			name, rest = text[:i], text[i:]
+		}
+		name = strings.ToLower(name)
+		if name != "key" && name != "id" {
+			continue
+		}
+		if anns.Has(name) || out.Has(name) {
+			continue
+		}
+
+		a := Annotation{Name: name, Pos: c.Pos}
+		if name == "id" {
+			// The value starts after the name and any spaces.
+			offset := len(c.Text) - len(strings.TrimLeft(rest, " \t"))
+			id := p.commentInteger(c, offset)
+			if id == nil {
+				continue
+			}
+			a.Params = []Expr{id}
+		}
+		p.debugf("Read annotation from comment: %s", a)
+		out = append(out, a)
+	}
+	return out
+}
+
+// Read the integer in a comment from the given byte offset, and nothing else
+// but spaces after it.
+func (p *parser) commentInteger(c Comment, offset int) Expr {
+	pos := c.Pos
+	pos.Offset += offset
+	pos.Column += offset
+
+	toks, err := LexFile(pos.Filename, []byte(c.Text[offset:]))
+	if err != nil || len(toks) != 1 || toks[0].ID != TokenIntegerLiteral {
+		p.report(&Diagnostic{
+			Code:     CodeUnexpectedToken,
+			Severity: SeverityError,
+			Pos:      pos,
+			Msg:      "expected an integer in " + c.Text,
+		})
+		return nil
+	}
+	return &LiteralExpr{Value: *toks[0].Literal, Text: toks[0].Value, ValuePos: pos}
+}
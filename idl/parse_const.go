@@ -1,49 +1,49 @@
 package idl
 
-import (
-	"fmt"
-)
-
 func (p *parser) parseConst() {
+	start := p.declStart()
+	anns := p.takeAnnotations()
 	p.advance()
 
 	constType := p.parseType()
 
 	if p.tok().ID != TokenIdentifier {
-		p.reportError(fmt.Errorf("expected constant name"))
+		p.reportError(CodeUnexpectedToken, "expected constant name")
 		return
 	}
 
+	constPos := p.tok().Pos
 	constName := p.parseIdentifier()
 
 	if p.tok().ID != TokenEquals {
-		p.reportError(fmt.Errorf("expected equals"))
+		p.reportError(CodeUnexpectedToken, "expected equals")
 		return
 	}
 
 	p.advance()
 
-	if p.tok().ID != TokenIdentifier && p.tok().ID != TokenStringLiteral {
-		p.reportError(fmt.Errorf("expected constant value"))
+	constExpr := p.parseConstExpr()
+	if constExpr == nil {
 		return
 	}
 
-	constValue := p.parseValue()
-
 	if p.tok().ID != TokenSemicolon {
-		p.reportError(fmt.Errorf("expected semicolon"))
+		p.reportError(CodeUnexpectedToken, "expected semicolon")
 		return
 	}
 
+	doc := p.comments(start, p.ppos)
 	p.advance()
-	p.currentModule.Constants = append(p.currentModule.Constants, Constant{
+	d := p.declarations()
+	d.Constants = append(d.Constants, Constant{
 		Member: Member{
-			Name: constName,
-			Type: constType,
+			Name:        constName,
+			Pos:         constPos,
+			Doc:         doc,
+			Type:        constType,
+			Annotations: anns,
 		},
-		Value: constValue,
+		Expr: constExpr,
 	})
-	if parseDebug {
-		fmt.Printf("Got constant: %s of type %s with value %s\n", constName, constType, constValue)
-	}
+	p.debugf("Got constant: %s of type %s with value %s", constName, constType, constExpr)
 }
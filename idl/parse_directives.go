@@ -1,64 +1,117 @@
 package idl
 
-import (
-	"fmt"
-)
+import "strings"
 
 // The entry point for directives.
 func (p *parser) parseTokenHash() {
 	p.advance() // skip #
 
 	if p.tok().ID != TokenIdentifier {
-		p.reportError(fmt.Errorf("unexpected non-word"))
+		p.reportError(CodeUnexpectedToken, "unexpected non-word")
 		return
 	}
 
 	directive := p.tok().Value
 
 	switch directive {
-	case "define":
-		p.parseDefineDirective()
 	case "include":
 		p.parseIncludeDirective()
+	case "pragma":
+		p.parsePragmaDirective()
 	default:
-		p.reportError(fmt.Errorf("unexpected directive: %s", directive))
+		p.reportError(CodeUnknownDirective, "unexpected directive: %s", directive)
 	}
 }
 
-func (p *parser) parseDefineDirective() {
+// Includes are loaded by the preprocessor. They are only left for the parser
+// when there is no ParseOptions.FS to load them from.
+func (p *parser) parseIncludeDirective() {
 	p.advance()
 
-	if p.tok().ID != TokenIdentifier {
-		p.reportError(fmt.Errorf("unexpected non-word"))
+	if p.tok().ID != TokenStringLiteral && p.tok().ID != TokenHeaderName {
+		p.reportError(CodeUnexpectedToken, "unexpected non-string-literal")
 		return
 	}
 
-	varName := p.tok().Value
+	p.report(&Diagnostic{
+		Code:     CodeIncludeNotLoaded,
+		Severity: SeverityWarning,
+		Pos:      p.tok().Pos,
+		End:      p.tok().End,
+		Msg:      "not including " + p.tok().Value + ": there is no ParseOptions.FS to load it from",
+	})
+	p.advance()
+}
+
+// A #pragma keylist, kept until the type it names can be looked up.
+type keylist struct {
+	// The type's name as written, and the scope it is looked up from
+	typeName string
+	scope    string
+	pos      Position
+
+	// The key members' names
+	keys []Token
+}
+
+// Pragmas other than keylist are ignored, as a C compiler would.
+func (p *parser) parsePragmaDirective() {
 	p.advanceAndDontSkipNewLines()
 
-	if !p.atEnd() && p.tok().ID == TokenIdentifier {
-		varValue := p.tok().Value
-		// Don't skip newlines so that:
-		// #define FOO
-		// Something
-		// isn't treated as "#define FOO Something".
-		p.advanceAndDontSkipNewLines()
-		fmt.Printf("Define: %s val %s\n", varName, varValue)
-	} else {
-		fmt.Printf("Define: %s no value\n", varName)
+	if p.atEnd() || p.tok().ID != TokenIdentifier {
+		p.reportError(CodeUnexpectedToken, "expected pragma name")
+		return
+	}
+
+	switch p.tok().Value {
+	case "keylist":
+		p.parseKeylistPragma()
+	default:
+		p.debugf("Ignoring pragma: %s", p.tok().Value)
+		p.skipLine()
 	}
 }
 
-func (p *parser) parseIncludeDirective() {
-	p.advance()
+// #pragma keylist Foo id name
+func (p *parser) parseKeylistPragma() {
+	p.advanceAndDontSkipNewLines()
 
-	if p.tok().ID != TokenStringLiteral {
-		p.reportError(fmt.Errorf("unexpected non-string-literal"))
+	// The type name is read without skipping newlines, as the pragma ends
+	// at the end of the line.
+	kl := keylist{pos: p.tok().Pos, scope: p.scopeName()}
+	for !p.atEnd() && (p.tok().ID == TokenIdentifier || p.tok().ID == TokenNamespace) {
+		if p.tok().ID == TokenIdentifier {
+			if kl.typeName != "" && !strings.HasSuffix(kl.typeName, "::") {
+				break
+			}
+			kl.typeName += p.tok().Value
+		} else {
+			kl.typeName += "::"
+		}
+		p.advanceAndDontSkipNewLines()
+	}
+
+	if kl.typeName == "" || strings.HasSuffix(kl.typeName, "::") {
+		p.reportError(CodeUnexpectedToken, "expected type name in keylist")
 		return
 	}
 
-	fileName := p.tok().Value
-	p.advance()
+	for !p.atEnd() && p.tok().ID != TokenEndLine {
+		if p.tok().ID != TokenIdentifier {
+			p.reportError(CodeUnexpectedToken, "expected key member name in keylist")
+			return
+		}
+		kl.keys = append(kl.keys, p.tok())
+		p.advanceAndDontSkipNewLines()
+	}
+
+	p.debugf("Keylist: %s keys %d", kl.typeName, len(kl.keys))
+	p.keylists = append(p.keylists, kl)
+}
 
-	fmt.Printf("Included: %s\n", fileName)
+// Skip the rest of a directive's line.
+func (p *parser) skipLine() {
+	for !p.atEnd() && p.tok().ID != TokenEndLine {
+		p.advanceAndDontSkipNewLines()
+	}
 }
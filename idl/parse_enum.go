@@ -1,53 +1,81 @@
 package idl
 
-import (
-	"fmt"
-)
-
 // Handle the start of an enum
 // enum MyEnum {
 func (p *parser) parseEnum() {
+	start := p.declStart()
+	anns := p.takeAnnotations()
 	p.advance()
 
 	if p.tok().ID != TokenIdentifier {
-		p.reportError(fmt.Errorf("expected enum name"))
+		p.reportError(CodeUnexpectedToken, "expected enum name")
 		return
 	}
 
+	enumPos := p.tok().Pos
 	enumName := p.parseIdentifier()
 
 	if p.tok().ID != TokenOpenBrace {
-		p.reportError(fmt.Errorf("expected enum contents"))
+		p.reportError(CodeUnexpectedToken, "expected enum contents")
 		return
 	}
 
+	brace := p.ppos
 	p.advance()
-	p.pushContext(contextEnum, enumName)
+	p.pushContext(contextEnum, enumName, enumPos)
+	p.currentEnum.Doc = p.comments(start, brace)
+	p.currentEnum.Annotations = anns
+	if bitBound := p.annotationParam(anns, "bit_bound"); bitBound != nil {
+		p.currentEnum.BitBound = &Bound{Expr: bitBound}
+	}
 }
 
 // Handle a member in an enum
 // MyValue,
+// @value(5) MyValue,
+// MyValue = 5,
 func (p *parser) parseEnumMember() {
 	// no leading advance, as we start at the name of the enum member.
 
 	if p.tok().ID != TokenIdentifier {
-		p.reportError(fmt.Errorf("expected enum value"))
+		p.reportError(CodeUnexpectedToken, "expected enum value")
 		return
 	}
 
 	enumName := p.tok().Value
+	enumPos := p.tok().Pos
+	start := p.declStart()
+	end := p.ppos
 	p.advance()
 
+	anns := p.takeAnnotations()
+	value := p.annotationParam(anns, "value")
+	if p.tok().ID == TokenEquals {
+		if value != nil {
+			p.reportError(CodeUnexpectedToken, "enumerator %s has both @value and a value", enumName)
+			return
+		}
+		p.advance()
+		if value = p.parseConstExpr(); value == nil {
+			return
+		}
+		end = p.lastTokenIndex()
+	}
+
+	if p.tok().ID == TokenComma {
+		end = p.ppos
+	}
 	for p.tok().ID == TokenComma {
 		// eat the comma(s)
 		p.advance()
 	}
 
-	if parseDebug {
-		fmt.Printf("Read enum member: %s\n", enumName)
-	}
-	p.currentEnum.Members = append(p.currentEnum.Members, Member{
-		Name: enumName,
-		// ### assign value?
+	p.debugf("Read enum member: %s", enumName)
+	p.currentEnum.Members = append(p.currentEnum.Members, Enumerator{
+		Name:        enumName,
+		Pos:         enumPos,
+		Doc:         p.comments(start, end),
+		Expr:        value,
+		Annotations: anns,
 	})
 }
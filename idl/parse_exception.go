@@ -0,0 +1,38 @@
+package idl
+
+// Handle the opening of an exception
+// exception NotFound {
+func (p *parser) parseException() {
+	start := p.declStart()
+	anns := p.takeAnnotations()
+	p.advance()
+
+	if p.tok().ID != TokenIdentifier {
+		p.reportError(CodeUnexpectedToken, "expected exception name")
+		return
+	}
+
+	exceptionPos := p.tok().Pos
+	exceptionName := p.parseIdentifier()
+
+	if p.tok().ID != TokenOpenBrace {
+		p.reportError(CodeUnexpectedToken, "expected exception contents")
+		return
+	}
+
+	brace := p.ppos
+	p.advance()
+	p.debugf("Read exception %s", exceptionName)
+	p.pushContext(contextException, exceptionName, exceptionPos)
+	p.currentExcept.Doc = p.comments(start, brace)
+	p.currentExcept.Annotations = anns
+}
+
+// Handle data members inside an exception
+// string reason;
+func (p *parser) parseExceptionMember() {
+	for _, m := range p.parseMember() {
+		p.debugf("Read exception member: %s of type %s", m.Name, m.Type)
+		p.currentExcept.Members = append(p.currentExcept.Members, m)
+	}
+}
@@ -0,0 +1,212 @@
+package idl
+
+// The binary operators, from the loosest binding to the tightest.
+var binaryOperatorPrecedence = [][]Operator{
+	{OpOr},
+	{OpXor},
+	{OpAnd},
+	{OpShl, OpShr},
+	{OpAdd, OpSub},
+	{OpMul, OpDiv, OpMod},
+}
+
+// Parse a constant expression, e.g. the value of a constant. Returns nil on
+// error.
+func (p *parser) parseConstExpr() Expr {
+	return p.parseBinaryExpr(0)
+}
+
+// Parse a series of binary operators at the given precedence level, with
+// operands of tighter binding.
+func (p *parser) parseBinaryExpr(level int) Expr {
+	if level == len(binaryOperatorPrecedence) {
+		return p.parseUnaryExpr()
+	}
+
+	x := p.parseBinaryExpr(level + 1)
+	if x == nil {
+		return nil
+	}
+
+	for {
+		op, ntoks, ok := p.binaryOperator()
+		if !ok || !hasOperator(binaryOperatorPrecedence[level], op) {
+			return x
+		}
+
+		opPos := p.tok().Pos
+		for i := 0; i < ntoks; i++ {
+			p.advance()
+		}
+
+		y := p.parseBinaryExpr(level + 1)
+		if y == nil {
+			return nil
+		}
+		x = &BinaryExpr{Op: op, X: x, Y: y, OpPos: opPos}
+	}
+}
+
+func hasOperator(ops []Operator, op Operator) bool {
+	for _, o := range ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// Find out whether the current token is a binary operator, and how many
+// tokens it is made of. Shifts are lexed as two < or > tokens, since a lone
+// > also closes a template, so the two must be right next to each other.
+func (p *parser) binaryOperator() (Operator, int, bool) {
+	switch p.tok().ID {
+	case TokenPipe:
+		return OpOr, 1, true
+	case TokenCaret:
+		return OpXor, 1, true
+	case TokenAmpersand:
+		return OpAnd, 1, true
+	case TokenPlus:
+		return OpAdd, 1, true
+	case TokenMinus:
+		return OpSub, 1, true
+	case TokenStar:
+		return OpMul, 1, true
+	case TokenSlash:
+		return OpDiv, 1, true
+	case TokenPercent:
+		return OpMod, 1, true
+	case TokenLessThan:
+		if p.adjacentTok(TokenLessThan) {
+			return OpShl, 2, true
+		}
+	case TokenGreaterThan:
+		// In sequence<sequence<long, 5>> the >> closes both templates.
+		if p.templateDepth == 0 && p.adjacentTok(TokenGreaterThan) {
+			return OpShr, 2, true
+		}
+	}
+	return 0, 0, false
+}
+
+// Is the token straight after the current one (without even whitespace in
+// between) of the given kind?
+func (p *parser) adjacentTok(id TokenID) bool {
+	if p.ppos+1 >= len(p.tokens) {
+		return false
+	}
+	next := p.tokens[p.ppos+1]
+	return next.ID == id && next.Adjacent
+}
+
+// Parse an operand, with an optional unary operator: -X, +X or ~X.
+func (p *parser) parseUnaryExpr() Expr {
+	var op Operator
+	switch p.tok().ID {
+	case TokenMinus:
+		op = OpSub
+	case TokenPlus:
+		op = OpAdd
+	case TokenTilde:
+		op = OpNot
+	default:
+		return p.parsePrimaryExpr()
+	}
+
+	opPos := p.tok().Pos
+	p.advance()
+	x := p.parseUnaryExpr()
+	if x == nil {
+		return nil
+	}
+	return &UnaryExpr{Op: op, X: x, OpPos: opPos}
+}
+
+// Parse a literal, a name, or an expression in parentheses.
+func (p *parser) parsePrimaryExpr() Expr {
+	tok := p.tok()
+
+	switch tok.ID {
+	case TokenIntegerLiteral, TokenFloatLiteral, TokenFixedLiteral:
+		p.advance()
+		return &LiteralExpr{Value: *tok.Literal, Text: tok.Value, ValuePos: tok.Pos}
+	case TokenCharLiteral, TokenWideCharLiteral:
+		p.advance()
+		return &LiteralExpr{Value: *tok.Literal, ValuePos: tok.Pos}
+	case TokenStringLiteral, TokenWideStringLiteral:
+		// Adjacent strings are joined up, as in C: "foo" "bar"
+		str := *tok.Literal
+		p.advance()
+		for p.tok().ID == TokenStringLiteral || p.tok().ID == TokenWideStringLiteral {
+			if p.tok().ID != tok.ID {
+				p.reportError(CodeUnexpectedToken, "cannot join narrow and wide strings")
+				return nil
+			}
+			str.Str += p.tok().Literal.Str
+			p.advance()
+		}
+		return &LiteralExpr{Value: str, ValuePos: tok.Pos}
+	case TokenIdentifier:
+		if tok.Value == "TRUE" || tok.Value == "FALSE" {
+			p.advance()
+			return &LiteralExpr{
+				Value:    Value{Kind: ValueBoolean, Bool: tok.Value == "TRUE"},
+				ValuePos: tok.Pos,
+			}
+		}
+		fallthrough
+	case TokenNamespace:
+		name := p.parseScopedName()
+		if name == "" {
+			return nil
+		}
+		return &NameExpr{Name: name, NamePos: tok.Pos}
+	case TokenOpenBracket:
+		p.advance()
+
+		// A >> in parentheses is always a shift.
+		depth := p.templateDepth
+		p.templateDepth = 0
+		x := p.parseConstExpr()
+		p.templateDepth = depth
+		if x == nil {
+			return nil
+		}
+
+		if p.tok().ID != TokenCloseBracket {
+			p.reportError(CodeUnexpectedToken, "expected: )")
+			return nil
+		}
+		p.advance()
+		return &ParenExpr{X: x, Lparen: tok.Pos}
+	}
+
+	p.reportError(CodeUnexpectedToken, "expected value")
+	return nil
+}
+
+// Read a scoped name, such as Foo, Foo::Bar or ::Foo::Bar. Returns an empty
+// string on error.
+func (p *parser) parseScopedName() string {
+	name := ""
+	if p.tok().ID == TokenNamespace {
+		name = "::"
+		p.advance()
+	}
+
+	for {
+		if p.tok().ID != TokenIdentifier {
+			p.reportError(CodeUnexpectedToken, "expected identifier")
+			return ""
+		}
+		name += p.tok().Value
+		p.advance()
+
+		if p.tok().ID != TokenNamespace {
+			return name
+		}
+		name += "::"
+		p.advance()
+	}
+}
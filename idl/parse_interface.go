@@ -1,37 +1,32 @@
 package idl
 
-import (
-	"fmt"
-)
-
 func (p *parser) parseInterface() {
+	start := p.declStart()
+	anns := p.takeAnnotations()
 	p.advance()
 
 	if p.tok().ID != TokenIdentifier {
-		p.reportError(fmt.Errorf("expected interface name"))
+		p.reportError(CodeUnexpectedToken, "expected interface name")
 		return
 	}
 
+	interfacePos := p.tok().Pos
 	interfaceName := p.parseIdentifier()
 
 	if p.tok().ID == TokenSemicolon {
 		// interface Foo;
-		if parseDebug {
-			fmt.Printf("Read empty interface %s\n", interfaceName)
-		}
-		p.advance()
-		p.pushContext(contextInterface, interfaceName)
-		p.popContext() // immediate pop as it's empty, just register in the AST
+		p.parseForwardDecl(ForwardInterface, start, interfaceName, interfacePos)
 		return
 	}
 
 	if p.tok().ID == TokenOpenBrace {
 		// interface Foo {
-		if parseDebug {
-			fmt.Printf("Read non-inheriting interface %s\n", interfaceName)
-		}
+		p.debugf("Read non-inheriting interface %s", interfaceName)
+		doc := p.comments(start, p.ppos)
 		p.advance()
-		p.pushContext(contextInterface, interfaceName)
+		p.pushContext(contextInterface, interfaceName, interfacePos)
+		p.currentIface.Doc = doc
+		p.currentIface.Annotations = anns
 		return
 	}
 
@@ -39,119 +34,128 @@ func (p *parser) parseInterface() {
 		// interface Foo : Bar {
 		p.advance()
 
-		if p.tok().ID != TokenIdentifier {
-			p.reportError(fmt.Errorf("expected interface inheritance name"))
+		if p.tok().ID != TokenIdentifier && p.tok().ID != TokenNamespace {
+			p.reportError(CodeUnexpectedToken, "expected interface inheritance name")
 			return
 		}
 
 		inherits := []string{}
-		for p.tok().ID == TokenIdentifier {
-			inheritsName := p.parseIdentifier()
-			inherits = append(inherits, inheritsName)
-			if parseDebug {
-				fmt.Printf("Got interface %s inheriting %s\n", interfaceName, inheritsName)
+		for p.tok().ID == TokenIdentifier || p.tok().ID == TokenNamespace {
+			inheritsName := p.parseScopedName()
+			if inheritsName == "" {
+				return
 			}
+			inherits = append(inherits, inheritsName)
+			p.debugf("Got interface %s inheriting %s", interfaceName, inheritsName)
 
 			// Multiple inheritance
 			if p.tok().ID == TokenComma {
 				p.advance()
 			} else if p.tok().ID != TokenOpenBrace {
-				p.reportError(fmt.Errorf("expected open brace"))
+				p.reportError(CodeUnexpectedToken, "expected open brace")
 				return
 			}
 		}
 
 		if p.tok().ID != TokenOpenBrace {
-			p.reportError(fmt.Errorf("expected open brace"))
+			p.reportError(CodeUnexpectedToken, "expected open brace")
 			return
 		}
 
+		doc := p.comments(start, p.ppos)
 		p.advance()
-		p.pushContext(contextInterface, interfaceName)
+		p.pushContext(contextInterface, interfaceName, interfacePos)
 		p.currentIface.Inherits = inherits
+		p.currentIface.Doc = doc
+		p.currentIface.Annotations = anns
 		return
 	}
 
-	p.reportError(fmt.Errorf("invalid interface definition"))
+	p.reportError(CodeUnexpectedToken, "invalid interface definition")
 	return
 }
 
 func (p *parser) parseInterfaceMember() {
+	start := p.declStart()
+	anns := p.takeAnnotations()
+
+	switch p.tok().Value {
+	case keywordReadonly, keywordAttribute:
+		p.parseAttribute(start, anns)
+		return
+	}
+
+	// oneway void ping();
+	oneway := false
+	if p.tok().Value == keywordOneway {
+		oneway = true
+		p.advance()
+	}
+
 	returnType := p.parseType()
 
 	if p.tok().ID != TokenIdentifier {
-		p.reportError(fmt.Errorf("expected interface member name"))
+		p.reportError(CodeUnexpectedToken, "expected interface member name")
 		return
 	}
 
+	memberPos := p.tok().Pos
 	memberName := p.parseIdentifier()
 
 	if p.tok().ID != TokenOpenBracket {
-		p.reportError(fmt.Errorf("expected open bracket"))
+		p.reportError(CodeUnexpectedToken, "expected open bracket")
 		return
 	}
 	p.advance()
 
-	if parseDebug {
-		fmt.Printf("Found interface member name %s returning type %s\n", memberName, returnType)
-	}
+	p.debugf("Found interface member name %s returning type %s", memberName, returnType)
 
 	m := Method{
 		Name:        memberName,
+		Pos:         memberPos,
 		ReturnValue: returnType,
+		Oneway:      oneway,
+		Annotations: anns,
 	}
 
 	if p.tok().ID == TokenCloseBracket {
 		// void foo();
-		p.advance()
-		if p.tok().ID != TokenSemicolon {
-			p.reportError(fmt.Errorf("expected semicolon"))
-			return
-		}
-		p.advance()
-		p.currentIface.Methods = append(p.currentIface.Methods, m)
-		return
+		goto out
 	}
 
 	for {
+		// @range(min = 0) in long count
+		p.parseAnnotations()
+		paramAnns := p.takeAnnotations()
+
 		if p.tok().ID != TokenIdentifier {
-			p.reportError(fmt.Errorf("expected direction"))
+			p.reportError(CodeUnexpectedToken, "expected direction")
 			return
 		}
 
+		param := MethodParameter{Pos: p.tok().Pos, Annotations: paramAnns}
 		switch p.tok().Value {
 		case keywordIn:
+			param.Direction = DirectionIn
 		case keywordOut:
+			param.Direction = DirectionOut
 		case keywordInOut:
-			break
+			param.Direction = DirectionInOut
 		default:
-			p.reportError(fmt.Errorf("unexpected direction"))
+			p.reportError(CodeUnexpectedToken, "unexpected direction")
 			return
 		}
-
-		direction := p.tok().Value
 		p.advance()
 
-		typeName := p.parseType()
-
-		paramName := ""
+		param.Type = p.parseType()
 
 		// Allow: "in foo bar" and "in foo"
 		if p.tok().ID == TokenIdentifier {
-			paramName = p.parseIdentifier()
+			param.Name = p.parseIdentifier()
 		}
 
-		fullName := fmt.Sprintf("%s %s", typeName, paramName)
-
-		if parseDebug {
-			fmt.Printf("Member takes: %s %s\n", direction, fullName)
-		}
-		m.Parameters = append(m.Parameters, MethodParameter{
-			Type: Type{
-				Name: fullName,
-			},
-			Direction: direction,
-		})
+		p.debugf("Member takes: %s", param)
+		m.Parameters = append(m.Parameters, param)
 
 		switch p.tok().ID {
 		case TokenCloseBracket:
@@ -163,5 +167,161 @@ func (p *parser) parseInterfaceMember() {
 	}
 
 out:
+	p.advance()
+
+	// void foo() raises (NotFound, Timeout);
+	if p.tok().ID == TokenIdentifier && p.tok().Value == keywordRaises {
+		p.advance()
+		if m.Raises = p.parseRaises(); m.Raises == nil {
+			return
+		}
+	}
+
+	// void foo() context ("x", "y");
+	if p.tok().ID == TokenIdentifier && p.tok().Value == keywordContext {
+		p.advance()
+		if m.Context = p.parseContext(); m.Context == nil {
+			return
+		}
+	}
+
+	if p.tok().ID != TokenSemicolon {
+		p.reportError(CodeUnexpectedToken, "expected semicolon")
+		return
+	}
+	m.Doc = p.comments(start, p.ppos)
+	p.advance()
 	p.currentIface.Methods = append(p.currentIface.Methods, m)
 }
+
+// readonly attribute long count;
+// attribute string name, title;
+// attribute string name getraises (NotFound) setraises (ReadOnly);
+func (p *parser) parseAttribute(start int, anns Annotations) {
+	a := Attribute{Annotations: anns}
+
+	if p.tok().Value == keywordReadonly {
+		a.Readonly = true
+		p.advance()
+		if p.tok().ID != TokenIdentifier || p.tok().Value != keywordAttribute {
+			p.reportError(CodeUnexpectedToken, "expected attribute after readonly")
+			return
+		}
+	}
+	p.advance()
+
+	a.Type = p.parseType()
+
+	type name struct {
+		name string
+		pos  Position
+	}
+	names := []name{}
+	for {
+		if p.tok().ID != TokenIdentifier {
+			p.reportError(CodeUnexpectedToken, "expected attribute name")
+			return
+		}
+		pos := p.tok().Pos
+		names = append(names, name{p.parseIdentifier(), pos})
+
+		if p.tok().ID != TokenComma {
+			break
+		}
+		p.advance()
+	}
+
+	// Only an attribute declared on its own can raise exceptions.
+	for len(names) == 1 && p.tok().ID == TokenIdentifier {
+		var raises *[]string
+		switch {
+		case a.Readonly && p.tok().Value == keywordRaises:
+			raises = &a.GetRaises
+		case !a.Readonly && p.tok().Value == keywordGetRaises && a.GetRaises == nil:
+			raises = &a.GetRaises
+		case !a.Readonly && p.tok().Value == keywordSetRaises && a.SetRaises == nil:
+			raises = &a.SetRaises
+		default:
+			p.reportError(CodeUnexpectedToken, "unexpected %s in attribute", p.tok().Value)
+			return
+		}
+		p.advance()
+		if *raises = p.parseRaises(); *raises == nil {
+			return
+		}
+	}
+
+	if p.tok().ID != TokenSemicolon {
+		p.reportError(CodeUnexpectedToken, "expected semicolon")
+		return
+	}
+	a.Doc = p.comments(start, p.ppos)
+	p.advance()
+
+	for _, n := range names {
+		a.Name = n.name
+		a.Pos = n.pos
+		p.debugf("Found interface attribute %s of type %s", a.Name, a.Type)
+		p.currentIface.Attributes = append(p.currentIface.Attributes, a)
+	}
+}
+
+// Read the exceptions named in a raises clause: (NotFound, Mod::Timeout).
+// Returns nil on error.
+func (p *parser) parseRaises() []string {
+	if p.tok().ID != TokenOpenBracket {
+		p.reportError(CodeUnexpectedToken, "expected open bracket")
+		return nil
+	}
+	p.advance()
+
+	raises := []string{}
+	for {
+		name := p.parseScopedName()
+		if name == "" {
+			return nil
+		}
+		raises = append(raises, name)
+
+		switch p.tok().ID {
+		case TokenComma:
+			p.advance()
+		case TokenCloseBracket:
+			p.advance()
+			return raises
+		default:
+			p.reportError(CodeUnexpectedToken, "expected comma or close bracket")
+			return nil
+		}
+	}
+}
+
+// Read the names in a context clause: ("x", "y"). Returns nil on error.
+func (p *parser) parseContext() []string {
+	if p.tok().ID != TokenOpenBracket {
+		p.reportError(CodeUnexpectedToken, "expected open bracket")
+		return nil
+	}
+	p.advance()
+
+	context := []string{}
+	for {
+		if p.tok().ID != TokenStringLiteral {
+			p.reportError(CodeUnexpectedToken, "expected string literal")
+			return nil
+		}
+		context = append(context, p.tok().Literal.Str)
+		p.advance()
+
+		switch p.tok().ID {
+		case TokenComma:
+			p.advance()
+		case TokenCloseBracket:
+			p.advance()
+			return context
+		default:
+			p.reportError(CodeUnexpectedToken, "expected comma or close bracket")
+			return nil
+		}
+	}
+}
@@ -1,24 +1,26 @@
 package idl
 
-import (
-	"fmt"
-)
-
 func (p *parser) parseModule() {
+	start := p.declStart()
+	anns := p.takeAnnotations()
 	p.advance()
 
 	if p.tok().ID != TokenIdentifier {
-		p.reportError(fmt.Errorf("expected module name"))
+		p.reportError(CodeUnexpectedToken, "expected module name")
 		return
 	}
 
+	modulePos := p.tok().Pos
 	moduleName := p.parseIdentifier()
 
 	if p.tok().ID != TokenOpenBrace {
-		p.reportError(fmt.Errorf("expected module contents"))
+		p.reportError(CodeUnexpectedToken, "expected module contents")
 		return
 	}
 
+	brace := p.ppos
 	p.advance()
-	p.pushContext(contextModule, moduleName)
+	p.pushContext(contextModule, moduleName, modulePos)
+	p.currentModule.Doc = p.comments(start, brace)
+	p.currentModule.Annotations = anns
 }
@@ -1,34 +1,40 @@
 package idl
 
-import (
-	"fmt"
-)
-
 // Handle the opening of a struct
 // struct Foo {
 func (p *parser) parseStruct() {
+	start := p.declStart()
+	anns := p.takeAnnotations()
 	p.advance()
 
 	if p.tok().ID != TokenIdentifier {
-		p.reportError(fmt.Errorf("expected struct name"))
+		p.reportError(CodeUnexpectedToken, "expected struct name")
 		return
 	}
 
+	structPos := p.tok().Pos
 	structName := p.parseIdentifier()
 
 	inherits := []string{}
 	switch p.tok().ID {
+	case TokenSemicolon:
+		// struct Foo;
+		p.parseForwardDecl(ForwardStruct, start, structName, structPos)
+		return
 	case TokenOpenBrace:
 		break
 	case TokenColon:
 		p.advance()
-		if p.tok().ID != TokenIdentifier {
-			p.reportError(fmt.Errorf("expected struct inheritance"))
+		if p.tok().ID != TokenIdentifier && p.tok().ID != TokenNamespace {
+			p.reportError(CodeUnexpectedToken, "expected struct inheritance")
 			return
 		}
 
-		for p.tok().ID == TokenIdentifier {
-			name := p.parseIdentifier()
+		for p.tok().ID == TokenIdentifier || p.tok().ID == TokenNamespace {
+			name := p.parseScopedName()
+			if name == "" {
+				return
+			}
 			inherits = append(inherits, name)
 
 			switch p.tok().ID {
@@ -42,38 +48,52 @@ func (p *parser) parseStruct() {
 	}
 
 	if p.tok().ID != TokenOpenBrace {
-		p.reportError(fmt.Errorf("expected struct contents"))
+		p.reportError(CodeUnexpectedToken, "expected struct contents")
 		return
 	}
 
+	brace := p.ppos
 	p.advance()
-	p.pushContext(contextStruct, structName)
+	p.pushContext(contextStruct, structName, structPos)
 	p.currentStruct.Inherits = inherits
+	p.currentStruct.Doc = p.comments(start, brace)
+	p.currentStruct.Annotations = anns
 }
 
 // Handle data members inside a struct
 // unsigned long data;
+// long x, y[3];
 func (p *parser) parseStructMember() {
+	for _, m := range p.parseMember() {
+		p.debugf("Read struct member: %s of type %s", m.Name, m.Type)
+		p.currentStruct.Members = append(p.currentStruct.Members, m)
+	}
+}
+
+// Read the members declared by a type and its declarators, as found in
+// structs and exceptions. Returns nil on error.
+func (p *parser) parseMember() []Member {
+	start := p.declStart()
+	anns := p.takeAnnotations()
 	typeName := p.parseType()
 
 	if p.tok().ID != TokenIdentifier {
-		p.reportError(fmt.Errorf("expected member name"))
-		return
+		p.reportError(CodeUnexpectedToken, "expected member name")
+		return nil
 	}
 
-	memberName := p.parseIdentifier()
+	members := p.parseDeclarators(typeName)
 
 	if p.tok().ID != TokenSemicolon {
-		p.reportError(fmt.Errorf("expected semicolon"))
-		return
+		p.reportError(CodeUnexpectedToken, "expected semicolon")
+		return nil
 	}
 
-	if parseDebug {
-		fmt.Printf("Read struct member: %s of type %s\n", memberName, typeName)
+	doc := p.comments(start, p.ppos)
+	anns = append(anns, p.commentAnnotations(doc.Trailing, anns)...)
+	for i := range members {
+		members[i].Doc = doc
+		members[i].Annotations = anns
 	}
-
-	p.currentStruct.Members = append(p.currentStruct.Members, Member{
-		Name: memberName,
-		Type: typeName,
-	})
+	return members
 }
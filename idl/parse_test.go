@@ -0,0 +1,450 @@
+package idl
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Lex and parse src, failing the test if parsing does not finish.
+func parseString(t *testing.T, src string) (Module, error) {
+	t.Helper()
+	return parseStringWithOptions(t, src, ParseOptions{})
+}
+
+// Like parseString, but with the given options.
+func parseStringWithOptions(t *testing.T, src string, opts ParseOptions) (Module, error) {
+	t.Helper()
+	toks, err := LexWithOptions("test.idl", []byte(src), opts)
+	if err != nil {
+		t.Fatalf("lexing %q: %s", src, err)
+	}
+
+	type result struct {
+		m   Module
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		m, err := ParseWithOptions(toks, opts)
+		done <- result{m, err}
+	}()
+	select {
+	case r := <-done:
+		return r.m, r.err
+	case <-time.After(5 * time.Second):
+		t.Fatalf("parsing %q did not finish", src)
+	}
+	return Module{}, nil
+}
+
+// The diagnostics in err, which must be an ErrorList.
+func diagnostics(t *testing.T, err error) ErrorList {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	list, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("error is a %T, not an ErrorList: %s", err, err)
+	}
+	return list
+}
+
+func TestParseRecovery(t *testing.T) {
+	tests := []struct {
+		src    string
+		errors []string
+	}{
+		{"}", []string{"test.idl:1:1: unexpected close brace"}},
+		{"}}", []string{"test.idl:1:1: unexpected close brace", "test.idl:1:2: unexpected close brace"}},
+		{"struct A { long x; }; };", []string{"test.idl:1:23: unexpected close brace"}},
+		{"module M { }; }", []string{"test.idl:1:15: unexpected close brace"}},
+		{"{ long v; };", []string{
+			"test.idl:1:3: unexpected keyword in global/module context: long",
+			"test.idl:1:11: unexpected close brace",
+		}},
+		{"@foo { long v; };", []string{
+			"test.idl:1:8: unexpected keyword in global/module context: long",
+			"test.idl:1:16: unexpected close brace",
+		}},
+		{"struct A { long x }; struct B { long y; };", []string{"test.idl:1:19: expected semicolon"}},
+		{"struct A { long x;", []string{"test.idl:1:18: unexpected EOF: unterminated struct A"}},
+	}
+
+	for _, test := range tests {
+		_, err := parseString(t, test.src)
+		got := []string{}
+		for _, d := range diagnostics(t, err) {
+			got = append(got, d.Error())
+		}
+		if strings.Join(got, "\n") != strings.Join(test.errors, "\n") {
+			t.Errorf("%q: got errors\n\t%s\nwant\n\t%s", test.src, strings.Join(got, "\n\t"), strings.Join(test.errors, "\n\t"))
+		}
+	}
+}
+
+func TestParsePartialModule(t *testing.T) {
+	src := `
+module M {
+	struct A { long a; };
+	struct B { long b c; };
+	struct C { long c; };
+	const long X = ;
+	const long Y = 2;
+};
+struct D { long d; };
+`
+	m, err := parseString(t, src)
+	if len(diagnostics(t, err)) != 2 {
+		t.Errorf("got errors %v, want 2", err)
+	}
+	if len(m.Modules) != 1 {
+		t.Fatalf("got %d modules, want 1", len(m.Modules))
+	}
+
+	structs := []string{}
+	for _, s := range m.Modules[0].Structs {
+		structs = append(structs, s.Name)
+	}
+	if got := strings.Join(structs, " "); got != "A B C" {
+		t.Errorf("got structs %s in M, want A B C", got)
+	}
+	if len(m.Modules[0].Constants) != 1 || m.Modules[0].Constants[0].Name != "Y" {
+		t.Errorf("got constants %v in M, want Y", m.Modules[0].Constants)
+	}
+	if len(m.Structs) != 1 || m.Structs[0].Name != "D" {
+		t.Errorf("got structs %v at file scope, want D", m.Structs)
+	}
+}
+
+func TestParseScopedNames(t *testing.T) {
+	decls := `
+module M {
+	interface I {
+		struct Inner { long v; };
+	};
+	struct S { long w; };
+};
+`
+	tests := []struct {
+		src  string
+		want string
+		err  string
+	}{
+		{"struct X { M::I::Inner x; };", "M::I::Inner", ""},
+		{"struct X { ::M::S x; };", "::M::S", ""},
+		{"typedef M::I::Inner X;", "M::I::Inner", ""},
+		{"struct X { sequence<M::I::Inner> x; };", "sequence<M::I::Inner>", ""},
+		{"struct X { map<string, ::M::I::Inner, 4> x; };", "map<string, ::M::I::Inner, 4>", ""},
+		{"module M { struct X { I::Inner x; }; };", "I::Inner", ""},
+		{"struct X { M::I::Nope x; };", "M::I::Nope", "unknown type M::I::Nope"},
+		{"struct X { Inner x; };", "Inner", "unknown type Inner"},
+		{"typedef sequence<M::Nope> X;", "sequence<M::Nope>", "unknown type M::Nope"},
+		{"struct X { M x; };", "M", "module M is not a type"},
+		{"interface A { typedef long T; }; interface B : A { T f(); };", "T", ""},
+		{"interface A { typedef long T; }; interface B : A { }; interface C : B { T f(); };", "T", ""},
+		{"interface B : M::I { Inner f(); };", "Inner", ""},
+		{"interface A { struct S { long v; }; }; interface B : A { }; struct X { B::S x; };", "B::S", ""},
+		{"interface B : A { }; interface A : B { T f(); };", "T", "unknown type T"},
+		{"interface A { }; interface B : A { T f(); };", "T", "unknown type T"},
+		{"struct X { M:: ; };", "", "expected identifier"},
+		{"::M::S x;", "", "unexpected keyword in global/module context: ::"},
+	}
+
+	for _, test := range tests {
+		m, err := parseString(t, decls+test.src)
+		got := ""
+		if len(m.Structs) > 0 && len(m.Structs[0].Members) > 0 {
+			got = m.Structs[0].Members[0].Type.String()
+		} else if len(m.Modules) > 1 && len(m.Modules[1].Structs) > 0 {
+			got = m.Modules[1].Structs[0].Members[0].Type.String()
+		} else if len(m.Modules) == 1 && len(m.Modules[0].Structs) > 1 {
+			got = m.Modules[0].Structs[1].Members[0].Type.String()
+		} else if len(m.TypeDefs) > 0 {
+			got = m.TypeDefs[0].Type.String()
+		} else if n := len(m.Interfaces); n > 0 && len(m.Interfaces[n-1].Methods) > 0 {
+			got = m.Interfaces[n-1].Methods[0].ReturnValue.String()
+		}
+		if got != test.want {
+			t.Errorf("%q: got type %q, want %q", test.src, got, test.want)
+		}
+		if got := messages(t, err); got != test.err {
+			t.Errorf("%q: got errors %q, want %q", test.src, got, test.err)
+		}
+	}
+
+	// Members declared together share their type, which is only reported
+	// once.
+	_, err := parseString(t, "struct X { Nope a, b; };")
+	if got, want := messages(t, err), "unknown type Nope"; got != want {
+		t.Errorf("got errors %q, want %q", got, want)
+	}
+}
+
+func TestParseDeclarationNames(t *testing.T) {
+	// Only names that refer to other declarations may be scoped.
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"module M { struct B { long a; }; }; struct S : ::M::B { long b; };", ""},
+		{"module M { interface J { }; exception E { }; }; interface I : M::J { void f() raises (::M::E); };", ""},
+		{"struct A::B { long a; };", "expected struct contents"},
+		{"enum A::E { X };", "expected enum contents"},
+		{"const long A::N = 1;", "expected equals"},
+		{"module A::B { };", "expected module contents"},
+		{"struct S { long a::b; };", "expected semicolon"},
+		{"typedef long A::T;", "expected semicolon, got: ::(16)"},
+	}
+
+	for _, test := range tests {
+		_, err := parseString(t, test.src)
+		if got := messages(t, err); got != test.err {
+			t.Errorf("%q: got errors %q, want %q", test.src, got, test.err)
+		}
+	}
+}
+
+func TestParseDanglingAnnotations(t *testing.T) {
+	tests := []struct {
+		src      string
+		warnings []string
+	}{
+		{"@appendable struct S { long x; };", nil},
+		{"struct S { @key long x; };", nil},
+		{"@key;", []string{"test.idl:1:1: annotation @key is not followed by a declaration"}},
+		{"@key @optional;", []string{
+			"test.idl:1:1: annotation @key is not followed by a declaration",
+			"test.idl:1:6: annotation @optional is not followed by a declaration",
+		}},
+		{"struct S { long x; @key };", []string{"test.idl:1:20: annotation @key is not followed by a declaration"}},
+		{"enum E { A, @value(1) };", []string{"test.idl:1:13: annotation @value is not followed by a declaration"}},
+		{"module M { struct S { long x; }; @final };", []string{"test.idl:1:34: annotation @final is not followed by a declaration"}},
+		{"struct S { long x; };\n@key", []string{"test.idl:2:1: annotation @key is not followed by a declaration"}},
+		{"@key; struct S { long x; };", []string{"test.idl:1:1: annotation @key is not followed by a declaration"}},
+
+		// Annotations on a broken declaration go with it.
+		{"@key struct S { long x }; struct T { long y; };", nil},
+		{"@key @range(1 2) struct S { long x; };", nil},
+	}
+
+	for _, test := range tests {
+		got := []string{}
+		opts := ParseOptions{DiagnosticSink: func(d *Diagnostic) {
+			if d.Code == CodeDanglingAnnotation {
+				got = append(got, d.Error())
+			}
+		}}
+		parseStringWithOptions(t, test.src, opts)
+		if strings.Join(got, "\n") != strings.Join(test.warnings, "\n") {
+			t.Errorf("%q: got warnings\n\t%s\nwant\n\t%s", test.src, strings.Join(got, "\n\t"), strings.Join(test.warnings, "\n\t"))
+		}
+	}
+
+	// The annotations do not move on to the next declaration.
+	m, _ := parseString(t, "@key; struct S { long x; };")
+	if len(m.Structs[0].Annotations) > 0 {
+		t.Errorf("got annotations %v on S, want none", m.Structs[0].Annotations)
+	}
+}
+
+// Write out the attributes and methods of an interface, one per line.
+func describeInterface(iface Interface) string {
+	lines := []string{}
+	for _, a := range iface.Attributes {
+		line := fmt.Sprintf("attribute %s %s", a.Type, a.Name)
+		if a.Readonly {
+			line = "readonly " + line
+		}
+		if len(a.GetRaises) > 0 {
+			line += " getraises(" + strings.Join(a.GetRaises, ", ") + ")"
+		}
+		if len(a.SetRaises) > 0 {
+			line += " setraises(" + strings.Join(a.SetRaises, ", ") + ")"
+		}
+		lines = append(lines, line)
+	}
+	for _, m := range iface.Methods {
+		params := []string{}
+		for _, param := range m.Parameters {
+			params = append(params, param.String())
+		}
+		line := fmt.Sprintf("%s %s(%s)", m.ReturnValue, m.Name, strings.Join(params, ", "))
+		if m.Oneway {
+			line = "oneway " + line
+		}
+		if len(m.Raises) > 0 {
+			line += " raises(" + strings.Join(m.Raises, ", ") + ")"
+		}
+		if len(m.Context) > 0 {
+			line += " context(" + strings.Join(m.Context, ", ") + ")"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestParseInterfaces(t *testing.T) {
+	exceptions := "exception E { }; exception F { };\n"
+	tests := []struct {
+		src  string
+		want string
+		err  string
+	}{
+		{"interface I { attribute long a; };", "attribute long a", ""},
+		{"interface I { readonly attribute string a, b; };", "readonly attribute string a\nreadonly attribute string b", ""},
+		{"interface I { readonly attribute long a raises (E); };", "readonly attribute long a getraises(E)", ""},
+		{"interface I { attribute long a getraises (E) setraises (E, F); };", "attribute long a getraises(E) setraises(E, F)", ""},
+		{"interface I { attribute long a setraises (F); };", "attribute long a setraises(F)", ""},
+		{"interface I { void f(); };", "void f()", ""},
+		{"interface I { oneway void f(in long x); };", "oneway void f(in long x)", ""},
+		{"interface I { long f() raises (E, ::F); };", "long f() raises(E, ::F)", ""},
+		{`interface I { void f() context ("a", "b*"); };`, `void f() context(a, b*)`, ""},
+		{`interface I { void f() raises (E) context ("a"); };`, `void f() raises(E) context(a)`, ""},
+
+		{"interface I { oneway long f(); };", "oneway long f()", "oneway method f must return void"},
+		{"interface I { oneway void f(out long x); };", "oneway void f(out long x)", "oneway method f cannot have out parameters"},
+		{"interface I { oneway void f() raises (E); };", "oneway void f() raises(E)", "oneway method f cannot raise exceptions"},
+		{"interface I { void f() raises (Nope); };", "void f() raises(Nope)", "unknown exception Nope"},
+		{"struct S { long x; }; interface I { void f() raises (S); };", "void f() raises(S)", "struct S is not an exception"},
+		{"interface I { attribute long a raises (E); };", "", "unexpected raises in attribute"},
+		{"interface I { readonly attribute long a setraises (E); };", "", "unexpected setraises in attribute"},
+		{"interface I { attribute long a getraises (E) getraises (F); };", "", "unexpected getraises in attribute"},
+		{"interface I { readonly attribute long a, b raises (E); };", "", "expected semicolon"},
+		{"interface I { void f() raises (); };", "", "expected identifier"},
+		{"interface I { void f() context (a); };", "", "expected string literal"},
+	}
+
+	for _, test := range tests {
+		m, err := parseString(t, exceptions+test.src)
+		got := ""
+		if n := len(m.Interfaces); n > 0 {
+			got = describeInterface(m.Interfaces[n-1])
+		}
+		if got != test.want {
+			t.Errorf("%q: got\n\t%s\nwant\n\t%s", test.src, got, test.want)
+		}
+		if got := messages(t, err); got != test.err {
+			t.Errorf("%q: got errors %q, want %q", test.src, got, test.err)
+		}
+	}
+}
+
+func TestParseExceptions(t *testing.T) {
+	tests := []struct {
+		src     string
+		members string
+		err     string
+	}{
+		{"exception E { };", "", ""},
+		{"exception E { long code; string<8> what, where; };", "long code, string<8> what, string<8> where", ""},
+		{"module M { exception E { sequence<long> codes; }; };", "sequence<long> codes", ""},
+		{"exception E { Nope x; };", "Nope x", "unknown type Nope"},
+		{"exception E { long x }; exception F { long y; };", "long y", "expected semicolon"},
+	}
+
+	for _, test := range tests {
+		m, err := parseString(t, test.src)
+		exceptions := m.Exceptions
+		if len(m.Modules) > 0 {
+			exceptions = m.Modules[0].Exceptions
+		}
+		members := []string{}
+		if n := len(exceptions); n > 0 {
+			for _, member := range exceptions[n-1].Members {
+				members = append(members, member.Type.String()+" "+member.Name)
+			}
+		}
+		if got := strings.Join(members, ", "); got != test.members {
+			t.Errorf("%q: got members %q, want %q", test.src, got, test.members)
+		}
+		if got := messages(t, err); got != test.err {
+			t.Errorf("%q: got errors %q, want %q", test.src, got, test.err)
+		}
+	}
+}
+
+func TestParseMethodParameters(t *testing.T) {
+	decls := "module M { struct S { long x; }; };\n"
+	tests := []struct {
+		src string
+
+		// Each parameter as direction|kind|type|name
+		params string
+		err    string
+	}{
+		{"void f(in long a, out string b, inout double c);", "in|primitive|long|a out|primitive|string|b inout|primitive|double|c", ""},
+		{"void f(in sequence<long> a, out sequence<M::S, 4> b);", "in|sequence|sequence<long>|a out|sequence|sequence<M::S, 4>|b", ""},
+		{"void f(inout M::S a, in ::M::S b);", "inout|named|M::S|a in|named|::M::S|b", ""},
+		{"void f(out map<string, sequence<M::S>> a);", "out|map|map<string, sequence<M::S>>|a", ""},
+		{"void f(in unsigned long long a, out string<8> b);", "in|primitive|unsigned long long|a out|primitive|string<8>|b", ""},
+		{"void f(in long, out M::S);", "in|primitive|long| out|named|M::S|", ""},
+
+		{"void f(long a);", "", "unexpected direction"},
+		{"void f(in long a;", "", "expected direction"},
+		{"void f(in M::Nope a);", "in|named|M::Nope|a", "unknown type M::Nope"},
+	}
+
+	for _, test := range tests {
+		m, err := parseString(t, decls+"interface I { "+test.src+" };")
+		params := []string{}
+		if len(m.Interfaces) > 0 && len(m.Interfaces[0].Methods) > 0 {
+			for _, p := range m.Interfaces[0].Methods[0].Parameters {
+				params = append(params, fmt.Sprintf("%s|%s|%s|%s", p.Direction, p.Type.Kind, p.Type, p.Name))
+			}
+		}
+		if got := strings.Join(params, " "); got != test.params {
+			t.Errorf("%q: got parameters %s, want %s", test.src, got, test.params)
+		}
+		if got := messages(t, err); got != test.err {
+			t.Errorf("%q: got errors %q, want %q", test.src, got, test.err)
+		}
+	}
+}
+
+func TestParseDeclarators(t *testing.T) {
+	tests := []struct {
+		src string
+
+		// The members of the struct, or the typedefs, as type and name
+		decls string
+		err   string
+	}{
+		{"struct S { long a, b[3], c; };", "long a, long[3] b, long c", ""},
+		{"struct S { sequence<string<4>> a, b[2][2]; };", "sequence<string<4>> a, sequence<string<4>>[2][2] b", ""},
+		{"typedef long A, B[3], C;", "long A, long[3] B, long C", ""},
+		{"typedef sequence<long> A[2], B;", "sequence<long>[2] A, sequence<long> B", ""},
+		{"exception E { short a, b; };", "short a, short b", ""},
+
+		{"struct S { long a, ; };", "long a", "expected name"},
+		{"struct S { long a b; };", "", "expected semicolon"},
+		{"struct S { long a, b c; };", "", "expected semicolon"},
+		{"typedef long A,;", "long A", "expected name"},
+	}
+
+	for _, test := range tests {
+		m, err := parseString(t, test.src)
+		decls := []string{}
+		for _, s := range m.Structs {
+			for _, member := range s.Members {
+				decls = append(decls, member.Type.String()+" "+member.Name)
+			}
+		}
+		for _, e := range m.Exceptions {
+			for _, member := range e.Members {
+				decls = append(decls, member.Type.String()+" "+member.Name)
+			}
+		}
+		for _, td := range m.TypeDefs {
+			decls = append(decls, td.Type.String()+" "+td.Name)
+		}
+		if got := strings.Join(decls, ", "); got != test.decls {
+			t.Errorf("%q: got %s, want %s", test.src, got, test.decls)
+		}
+		if got := messages(t, err); got != test.err {
+			t.Errorf("%q: got errors %q, want %q", test.src, got, test.err)
+		}
+	}
+}
@@ -1,32 +1,31 @@
 package idl
 
-import (
-	"fmt"
-)
-
 func (p *parser) parseTypedef() {
+	start := p.declStart()
+	anns := p.takeAnnotations()
 	p.advance()
 
 	fromName := p.parseType()
 
 	if p.tok().ID != TokenIdentifier {
-		p.reportError(fmt.Errorf("expected to name"))
+		p.reportError(CodeUnexpectedToken, "expected to name")
 		return
 	}
 
-	toName := p.parseIdentifier()
+	toNames := p.parseDeclarators(fromName)
 
 	if p.tok().ID != TokenSemicolon {
-		p.reportError(fmt.Errorf("expected semicolon, got: %s", p.tok().ID))
+		p.reportError(CodeUnexpectedToken, "expected semicolon, got: %s", p.tok().ID)
 		return
 	}
 
+	doc := p.comments(start, p.ppos)
 	p.advance()
-	p.currentModule.TypeDefs = append(p.currentModule.TypeDefs, TypeDef{
-		Name: toName,
-		Type: fromName,
-	})
-	if parseDebug {
-		fmt.Printf("Typedef: %s to %s\n", fromName, toName)
+	d := p.declarations()
+	for _, to := range toNames {
+		to.Doc = doc
+		to.Annotations = anns
+		d.TypeDefs = append(d.TypeDefs, TypeDef(to))
+		p.debugf("Typedef: %s to %s", to.Type, to.Name)
 	}
 }
@@ -1,128 +1,130 @@
 package idl
 
-import (
-	"fmt"
-)
-
 // union LogServiceRequestData switch (DdsData::LogServiceRequestType) {
 func (p *parser) parseUnion() {
+	start := p.declStart()
+	anns := p.takeAnnotations()
 	p.advance()
 
+	unionPos := p.tok().Pos
 	unionName := p.parseIdentifier()
 
 	if unionName == "" {
-		p.reportError(fmt.Errorf("expected type in union"))
+		p.reportError(CodeUnexpectedToken, "expected type in union")
+		return
+	}
+
+	if p.tok().ID == TokenSemicolon {
+		// union Foo;
+		p.parseForwardDecl(ForwardUnion, start, unionName, unionPos)
 		return
 	}
 
 	switchKeyword := p.parseIdentifier()
 
 	if switchKeyword != keywordSwitch {
-		p.reportError(fmt.Errorf("expected switch after type in union"))
+		p.reportError(CodeUnexpectedToken, "expected switch after type in union")
 		return
 	}
 
 	if p.tok().ID != TokenOpenBracket {
-		p.reportError(fmt.Errorf("expected open bracket before type in union"))
+		p.reportError(CodeUnexpectedToken, "expected open bracket before type in union")
 		return
 	}
 
 	p.advance()
 
+	errs := len(p.errors)
 	switchType := p.parseType()
 
-	if p.hasError() {
-		p.reportError(fmt.Errorf("expected switch on type in union"))
+	if len(p.errors) > errs {
 		return
 	}
 
 	if p.tok().ID != TokenCloseBracket {
-		p.reportError(fmt.Errorf("expected close bracket after type in union"))
+		p.reportError(CodeUnexpectedToken, "expected close bracket after type in union")
 		return
 	}
 
 	p.advance()
 
 	if p.tok().ID != TokenOpenBrace {
-		p.reportError(fmt.Errorf("expected open brace after type in union"))
+		p.reportError(CodeUnexpectedToken, "expected open brace after type in union")
 		return
 	}
 
-	if parseDebug {
-		fmt.Printf("Read union %s switching on type %s\n", unionName, switchType)
-	}
+	p.debugf("Read union %s switching on type %s", unionName, switchType)
 
-	p.pushContext(contextUnion, unionName)
+	p.pushContext(contextUnion, unionName, unionPos)
 	p.currentUnion.Discriminant = switchType
+	p.currentUnion.Doc = p.comments(start, p.ppos)
+	p.currentUnion.Annotations = anns
 }
 
 //    case (DdsData::AnalogTimeSeries):
 //          DdsData::TimeSeriesRequest analogTimeSeries; //@ID 1
+//    case 1: case 2:
+//    default:
+//          long values[2];
 func (p *parser) parseUnionMember() {
-
-	keywordName := p.parseIdentifier()
-
-	if keywordName != keywordCase {
-		p.reportError(fmt.Errorf("expected case in union member"))
+	start := p.declStart()
+	member := UnionMember{Annotations: p.takeAnnotations()}
+
+	for p.tok().ID == TokenIdentifier && (p.tok().Value == keywordCase || p.tok().Value == keywordDefault) {
+		if p.tok().Value == keywordDefault {
+			p.advance()
+			member.IsDefault = true
+		} else {
+			p.advance()
+			label := p.parseConstExpr()
+			if label == nil {
+				return
+			}
+			member.Labels = append(member.Labels, CaseLabel{Expr: label})
+		}
+
+		if p.tok().ID != TokenColon {
+			p.reportError(CodeUnexpectedToken, "expected colon after case label in union member")
+			return
+		}
+
+		p.advance()
+	}
+
+	if len(member.Labels) == 0 && !member.IsDefault {
+		p.reportError(CodeUnexpectedToken, "expected case in union member")
 		return
 	}
 
-	if p.tok().ID != TokenOpenBracket {
-		p.reportError(fmt.Errorf("expected open bracket before type in union member"))
-		return
-	}
-
-	p.advance()
-
-	switchType := p.parseType()
-
-	if p.hasError() {
-		p.reportError(fmt.Errorf("expected type in union member"))
-		return
-	}
-
-	if p.tok().ID != TokenCloseBracket {
-		p.reportError(fmt.Errorf("expected close bracket after type in union member"))
-		return
-	}
-
-	p.advance()
-
-	if p.tok().ID != TokenColon {
-		p.reportError(fmt.Errorf("expected colon after close bracket in union member"))
-		return
-	}
-
-	p.advance()
+	// case 1: @key long x;
+	p.parseAnnotations()
+	member.Annotations = append(member.Annotations, p.takeAnnotations()...)
 
 	if p.tok().ID != TokenIdentifier {
-		p.reportError(fmt.Errorf("expected var type in union member"))
+		p.reportError(CodeUnexpectedToken, "expected var type in union member")
 		return
 	}
 
 	varType := p.parseType()
 
 	if p.tok().ID != TokenIdentifier {
-		p.reportError(fmt.Errorf("expected var name in union member"))
+		p.reportError(CodeUnexpectedToken, "expected var name in union member")
 		return
 	}
 
-	varName := p.parseIdentifier()
+	member.Pos = p.tok().Pos
+	member.MemberName, member.MemberType = p.parseDeclarator(varType)
 
 	if p.tok().ID != TokenSemicolon {
-		p.reportError(fmt.Errorf("expected semicolon at the end of  union member"))
+		p.reportError(CodeUnexpectedToken, "expected semicolon at the end of  union member")
 		return
 	}
 
+	member.Doc = p.comments(start, p.ppos)
+	member.Annotations = append(member.Annotations, p.commentAnnotations(member.Doc.Trailing, member.Annotations)...)
 	p.advance()
 
-	if parseDebug {
-		fmt.Printf("Read union member of type %s with var name %s (%s)\n", switchType, varName, varType)
-	}
+	p.debugf("Read union member of type %s with var name %s", member.MemberType, member.MemberName)
 
-	p.currentUnion.Members = append(p.currentUnion.Members, UnionMember{
-		CaseValue:  switchType,
-		MemberType: varType,
-		MemberName: varName,
-	})
+	p.currentUnion.Members = append(p.currentUnion.Members, member)
 }
@@ -0,0 +1,41 @@
+package idl
+
+import (
+	"fmt"
+)
+
+// Position describes a location in an IDL source file.
+type Position struct {
+	// The name of the file, as given to LexFile or found by an #include, if
+	// known
+	Filename string
+
+	// The byte offset into the file, starting at 0
+	Offset int
+
+	// The line number, starting at 1
+	Line int
+
+	// The column number (in bytes), starting at 1
+	Column int
+}
+
+// IsValid reports whether the position refers to an actual location.
+func (pos Position) IsValid() bool {
+	return pos.Line > 0
+}
+
+// Turn a Position into a string of the form "file:line:column".
+func (pos Position) String() string {
+	s := pos.Filename
+	if pos.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+	}
+	if s == "" {
+		s = "-"
+	}
+	return s
+}
@@ -0,0 +1,978 @@
+package idl
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"path"
+	"sort"
+	"strconv"
+)
+
+// A macro defined by #define, or through ParseOptions.Defines.
+type macro struct {
+	name string
+	pos  Position
+
+	// Set for a function-like macro, e.g. #define MAX(a, b) ..., along with
+	// the names of its parameters
+	function bool
+	params   []string
+
+	// The tokens the macro is replaced with
+	body []Token
+}
+
+// Is the macro defined the same way as another? A macro may be defined again
+// without a warning if so.
+func (m *macro) sameAs(o *macro) bool {
+	if m.function != o.function || len(m.params) != len(o.params) || len(m.body) != len(o.body) {
+		return false
+	}
+	for i := range m.params {
+		if m.params[i] != o.params[i] {
+			return false
+		}
+	}
+	for i := range m.body {
+		if m.body[i].ID != o.body[i].ID || m.body[i].Value != o.body[i].Value {
+			return false
+		}
+	}
+	return true
+}
+
+// An #if, #ifdef or #ifndef, and the #elif and #else branches after it.
+type conditional struct {
+	// Where the #if is
+	pos Position
+
+	// Whether the lines of the current branch are kept
+	active bool
+
+	// Whether no later branch can be kept, because one has been already, or
+	// because the whole conditional is in a branch that is skipped
+	done bool
+
+	// Whether the #else has been read
+	sawElse bool
+}
+
+// A file being read, and the position of the #include that included it.
+type includedFile struct {
+	name string
+	pos  Position
+}
+
+// A preprocessor runs the # directives in a series of tokens, as the C
+// preprocessor does for C.
+type preprocessor struct {
+	opts   ParseOptions
+	errors ErrorList
+
+	// Macros defined so far, by name
+	macros map[string]*macro
+
+	// The conditionals the current line is in, innermost last, and the
+	// index of the first one opened in the current file
+	conds []conditional
+	base  int
+
+	// The files being read, innermost last, and every file read so far, by
+	// name
+	files    []includedFile
+	included map[string]bool
+
+	// Lines read since the last directive, which have not had their macros
+	// expanded yet. They are kept together, as a macro's arguments may run
+	// over several lines.
+	pending []Token
+
+	out []Token
+}
+
+// Preprocess runs the preprocessor directives in a series of tokens, and
+// returns the tokens that are left:
+//
+//   - macros are defined by #define (or ParseOptions.Defines) and removed by
+//     #undef, and both object-like and function-like macros are expanded
+//   - lines in the branches of #if, #ifdef, #ifndef, #elif and #else that are
+//     not taken are removed
+//   - #include loads the file it names from ParseOptions.FS, once per file
+//   - #pragma is left for the parser
+//
+// Each line that is removed leaves its newline behind, so that comments stay
+// apart from the declarations they were apart from. The # and ## operators
+// are not supported.
+//
+// Parse preprocesses the tokens it is given, so this is only needed to look
+// at the preprocessed tokens themselves.
+func Preprocess(toks []Token, opts ParseOptions) ([]Token, error) {
+	out, errs := preprocess(toks, opts)
+	return out, errs.Err()
+}
+
+func preprocess(toks []Token, opts ParseOptions) ([]Token, ErrorList) {
+	pp := &preprocessor{
+		opts:     opts,
+		macros:   make(map[string]*macro),
+		included: make(map[string]bool),
+	}
+	pp.predefine()
+
+	name := ""
+	if len(toks) > 0 {
+		name = path.Clean(toks[0].Pos.Filename)
+	}
+	pp.file(name, Position{}, toks)
+	return pp.out, pp.errors
+}
+
+// Preprocess the tokens of a file, included at the given position.
+func (pp *preprocessor) file(name string, pos Position, toks []Token) {
+	pp.included[name] = true
+	pp.files = append(pp.files, includedFile{name: name, pos: pos})
+	base := pp.base
+	pp.base = len(pp.conds)
+
+	for i := 0; i < len(toks); {
+		// Find the end of the line, including its newline.
+		end := i
+		for end < len(toks) && toks[end].ID != TokenEndLine {
+			end++
+		}
+		if end < len(toks) {
+			end++
+		}
+		pp.line(toks[i:end])
+		i = end
+	}
+	pp.flush()
+
+	// Conditionals do not carry on into the including file.
+	for _, c := range pp.conds[pp.base:] {
+		pp.errorf(CodeUnbalancedConditional, c.pos, "#if without #endif")
+	}
+	pp.conds = pp.conds[:pp.base]
+	pp.base = base
+	pp.files = pp.files[:len(pp.files)-1]
+}
+
+// Pass a diagnostic on to the options' sink and logger, and keep errors.
+func (pp *preprocessor) report(d *Diagnostic) {
+	pp.opts.report(d)
+	if d.Severity == SeverityError {
+		pp.errors.Add(d)
+	}
+}
+
+// Report an error at the given position.
+func (pp *preprocessor) errorf(code DiagnosticCode, pos Position, format string, args ...interface{}) {
+	pp.report(&Diagnostic{
+		Code:     code,
+		Severity: SeverityError,
+		Pos:      pos,
+		Msg:      fmt.Sprintf(format, args...),
+	})
+}
+
+// Define the macros given in the options, as if by #defines at the start of
+// the file.
+func (pp *preprocessor) predefine() {
+	names := make([]string, 0, len(pp.opts.Defines))
+	for name := range pp.opts.Defines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		toks, err := LexWithOptions("<command line>", []byte(name+" "+pp.opts.Defines[name]), pp.opts)
+		if err != nil {
+			pp.errors = append(pp.errors, err.(ErrorList)...)
+			continue
+		}
+		pp.define(Token{ID: TokenHash}, withoutSkipped(toks))
+	}
+
+	for _, name := range pp.opts.Undefines {
+		delete(pp.macros, name)
+	}
+}
+
+// Return the tokens that are not comments or newlines.
+func withoutSkipped(toks []Token) []Token {
+	out := []Token{}
+	for _, tok := range toks {
+		if !isSkippedToken(tok.ID) {
+			out = append(out, tok)
+		}
+	}
+	return out
+}
+
+// Is the current line in a branch that is kept?
+func (pp *preprocessor) active() bool {
+	return len(pp.conds) == 0 || pp.conds[len(pp.conds)-1].active
+}
+
+// Keep only the newline of a line that is removed.
+func (pp *preprocessor) keepNewline(line []Token) {
+	if n := len(line); n > 0 && line[n-1].ID == TokenEndLine {
+		pp.out = append(pp.out, line[n-1])
+	}
+}
+
+// Expand the macros in the lines read since the last directive.
+func (pp *preprocessor) flush() {
+	pp.out = append(pp.out, pp.expand(pp.pending, make(map[string]bool))...)
+	pp.pending = nil
+}
+
+// Handle a line of tokens, ending with its newline if it has one.
+func (pp *preprocessor) line(line []Token) {
+	first := 0
+	for first < len(line) && line[first].ID == TokenComment {
+		first++
+	}
+
+	if first == len(line) || line[first].ID != TokenHash {
+		if pp.active() {
+			pp.pending = append(pp.pending, line...)
+		} else {
+			pp.keepNewline(line)
+		}
+		return
+	}
+
+	pp.flush()
+	hash := line[first]
+	args := withoutSkipped(line[first+1:])
+
+	if len(args) == 0 {
+		// A # on its own does nothing.
+		pp.keepNewline(line)
+		return
+	}
+
+	name := args[0]
+	args = args[1:]
+	if name.ID != TokenIdentifier {
+		if pp.active() {
+			pp.errorf(CodeUnexpectedToken, name.Pos, "expected directive name")
+		}
+		pp.keepNewline(line)
+		return
+	}
+
+	switch name.Value {
+	case "if", "ifdef", "ifndef":
+		pp.parseIf(hash, name.Value, args)
+	case "elif":
+		pp.parseElif(hash, args)
+	case "else":
+		pp.parseElse(hash)
+	case "endif":
+		pp.parseEndif(hash)
+	default:
+		if pp.active() {
+			pp.directive(hash, name.Value, args, line)
+			return
+		}
+	}
+	pp.keepNewline(line)
+}
+
+// Handle a directive other than a conditional, in a branch that is kept.
+func (pp *preprocessor) directive(hash Token, name string, args []Token, line []Token) {
+	switch name {
+	case "define":
+		pp.define(hash, args)
+	case "undef":
+		if name, ok := pp.macroName(hash, "undef", args); ok {
+			delete(pp.macros, name)
+			pp.opts.debugf("Undefine: %s", name)
+		}
+	case "include":
+		if pp.opts.FS == nil {
+			// The parser notes the include, but there is nowhere to
+			// load it from.
+			pp.out = append(pp.out, line...)
+			return
+		}
+		pp.include(hash, args)
+	case "pragma":
+		pp.out = append(pp.out, line...)
+		return
+	case "error", "warning":
+		d := &Diagnostic{
+			Code:     CodeErrorDirective,
+			Severity: SeverityError,
+			Pos:      hash.Pos,
+			Msg:      "#" + name,
+		}
+		if name == "warning" {
+			d.Severity = SeverityWarning
+		}
+		for _, tok := range args {
+			d.Msg += " " + tokenText(tok)
+		}
+		pp.report(d)
+	case "line", "ident":
+		pp.opts.debugf("Ignoring directive: %s", name)
+	default:
+		pp.errorf(CodeUnknownDirective, hash.Pos, "unexpected directive: %s", name)
+	}
+	pp.keepNewline(line)
+}
+
+// #include "file"
+// #include <file>
+func (pp *preprocessor) include(hash Token, args []Token) {
+	if len(args) == 0 || (args[0].ID != TokenStringLiteral && args[0].ID != TokenHeaderName) {
+		pp.errorf(CodeUnexpectedToken, hash.Pos, "expected file name after #include")
+		return
+	}
+	file := args[0]
+
+	name, data, err := pp.findInclude(file)
+	if err != nil {
+		pp.errorf(CodeIncludeNotFound, file.Pos, "cannot include %s: %s", file.Value, err)
+		return
+	}
+
+	for i, f := range pp.files {
+		if f.name != name {
+			continue
+		}
+		d := &Diagnostic{
+			Code:     CodeIncludeCycle,
+			Severity: SeverityWarning,
+			Pos:      file.Pos,
+			Msg:      name + " includes itself",
+		}
+		for _, g := range pp.files[i+1:] {
+			d.Related = append(d.Related, RelatedInformation{Pos: g.pos, Msg: "including " + g.name})
+		}
+		pp.report(d)
+		return
+	}
+
+	if pp.included[name] {
+		pp.opts.debugf("Already included: %s", name)
+		return
+	}
+
+	toks, err := LexWithOptions(name, data, pp.opts)
+	if err != nil {
+		pp.errors = append(pp.errors, err.(ErrorList)...)
+	}
+	pp.opts.debugf("Including: %s", name)
+	pp.file(name, file.Pos, toks)
+}
+
+// Find and read the file named by an #include. A quoted name is looked for
+// next to the including file, and then in the include paths; a name in angle
+// brackets only in the include paths.
+func (pp *preprocessor) findInclude(file Token) (string, []byte, error) {
+	dirs := []string{}
+	if file.ID == TokenStringLiteral {
+		dirs = append(dirs, path.Dir(pp.files[len(pp.files)-1].name))
+	}
+	dirs = append(dirs, pp.opts.IncludePaths...)
+
+	for _, dir := range dirs {
+		name := path.Join(dir, file.Value)
+		if !fs.ValidPath(name) {
+			continue
+		}
+		data, err := fs.ReadFile(pp.opts.FS, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		return name, data, err
+	}
+	return "", nil, fs.ErrNotExist
+}
+
+// Write a token as it might appear in the source, for messages.
+func tokenText(tok Token) string {
+	switch tok.ID {
+	case TokenStringLiteral:
+		return strconv.Quote(tok.Value)
+	case TokenWideStringLiteral:
+		return "L" + strconv.Quote(tok.Value)
+	case TokenCharLiteral:
+		return "'" + tok.Value + "'"
+	case TokenWideCharLiteral:
+		return "L'" + tok.Value + "'"
+	}
+	if tok.Value != "" {
+		return tok.Value
+	}
+	return tok.ID.symbol()
+}
+
+// Read the name of the macro a directive is about.
+func (pp *preprocessor) macroName(hash Token, directive string, args []Token) (string, bool) {
+	if len(args) == 0 || args[0].ID != TokenIdentifier {
+		pp.errorf(CodeUnexpectedToken, hash.Pos, "expected macro name after #%s", directive)
+		return "", false
+	}
+	return args[0].Value, true
+}
+
+// #define NAME replacement
+// #define NAME(a, b) replacement
+func (pp *preprocessor) define(hash Token, args []Token) {
+	name, ok := pp.macroName(hash, "define", args)
+	if !ok {
+		return
+	}
+	m := &macro{name: name, pos: args[0].Pos}
+	rest := args[1:]
+
+	// Parameters start with a bracket straight after the name. With a space
+	// in between, the bracket is part of the replacement.
+	if len(rest) > 0 && rest[0].ID == TokenOpenBracket && rest[0].Pos.Offset == args[0].End.Offset {
+		m.function = true
+		m.params = []string{}
+		i := 1
+		for {
+			if i < len(rest) && rest[i].ID == TokenCloseBracket && len(m.params) == 0 {
+				break
+			}
+			if i >= len(rest) || rest[i].ID != TokenIdentifier {
+				pp.errorf(CodeUnexpectedToken, rest[0].Pos, "expected parameter name in #define of %s", name)
+				return
+			}
+			m.params = append(m.params, rest[i].Value)
+			i++
+			if i < len(rest) && rest[i].ID == TokenComma {
+				i++
+				continue
+			}
+			if i < len(rest) && rest[i].ID == TokenCloseBracket {
+				break
+			}
+			pp.errorf(CodeUnexpectedToken, rest[0].Pos, "expected , or ) in #define of %s", name)
+			return
+		}
+		rest = rest[i+1:]
+	}
+	m.body = rest
+
+	if old := pp.macros[name]; old != nil && !old.sameAs(m) {
+		pp.report(&Diagnostic{
+			Code:     CodeMacroRedefined,
+			Severity: SeverityWarning,
+			Pos:      m.pos,
+			Msg:      "macro " + name + " is defined again differently",
+			Related: []RelatedInformation{{
+				Pos: old.pos,
+				Msg: "previous definition",
+			}},
+		})
+	}
+	pp.macros[name] = m
+	pp.opts.debugf("Define: %s with %d tokens", name, len(m.body))
+}
+
+// Open a conditional.
+// #ifdef NAME
+// #ifndef NAME
+// #if expression
+func (pp *preprocessor) parseIf(hash Token, directive string, args []Token) {
+	c := conditional{pos: hash.Pos}
+	if !pp.active() {
+		c.done = true
+	} else if directive == "if" {
+		c.active = pp.condition(hash, directive, args)
+		c.done = c.active
+	} else if name, ok := pp.macroName(hash, directive, args); ok {
+		c.active = (pp.macros[name] != nil) == (directive == "ifdef")
+		c.done = c.active
+	}
+	pp.conds = append(pp.conds, c)
+}
+
+// #elif expression
+func (pp *preprocessor) parseElif(hash Token, args []Token) {
+	if len(pp.conds) == pp.base {
+		pp.errorf(CodeUnbalancedConditional, hash.Pos, "#elif without #if")
+		return
+	}
+	c := &pp.conds[len(pp.conds)-1]
+	if c.sawElse {
+		pp.errorf(CodeUnbalancedConditional, hash.Pos, "#elif after #else")
+	}
+	if c.done {
+		c.active = false
+		return
+	}
+	c.active = pp.condition(hash, "elif", args)
+	c.done = c.active
+}
+
+// #else
+func (pp *preprocessor) parseElse(hash Token) {
+	if len(pp.conds) == pp.base {
+		pp.errorf(CodeUnbalancedConditional, hash.Pos, "#else without #if")
+		return
+	}
+	c := &pp.conds[len(pp.conds)-1]
+	if c.sawElse {
+		pp.errorf(CodeUnbalancedConditional, hash.Pos, "#else after #else")
+	}
+	c.sawElse = true
+	c.active = !c.done
+	c.done = true
+}
+
+// #endif
+func (pp *preprocessor) parseEndif(hash Token) {
+	if len(pp.conds) == pp.base {
+		pp.errorf(CodeUnbalancedConditional, hash.Pos, "#endif without #if")
+		return
+	}
+	pp.conds = pp.conds[:len(pp.conds)-1]
+}
+
+// Work out whether the expression of an #if or #elif is true.
+func (pp *preprocessor) condition(hash Token, directive string, args []Token) bool {
+	// defined(NAME) and defined NAME are replaced first, so that NAME is
+	// not expanded.
+	toks := []Token{}
+	for i := 0; i < len(args); i++ {
+		if args[i].ID != TokenIdentifier || args[i].Value != "defined" {
+			toks = append(toks, args[i])
+			continue
+		}
+		j := i + 1
+		brackets := j < len(args) && args[j].ID == TokenOpenBracket
+		if brackets {
+			j++
+		}
+		if j >= len(args) || args[j].ID != TokenIdentifier {
+			pp.errorf(CodeUnexpectedToken, args[i].Pos, "expected macro name after defined")
+			return false
+		}
+		defined := pp.macros[args[j].Value] != nil
+		if brackets {
+			j++
+			if j >= len(args) || args[j].ID != TokenCloseBracket {
+				pp.errorf(CodeUnexpectedToken, args[i].Pos, "expected ) after defined(%s", args[j-1].Value)
+				return false
+			}
+		}
+		toks = append(toks, integerToken(args[i].Pos, defined))
+		i = j
+	}
+
+	e := ppExpr{
+		pp:        pp,
+		toks:      pp.expand(toks, make(map[string]bool)),
+		directive: directive,
+		pos:       hash.Pos,
+	}
+	v, ok := e.evaluate()
+	return ok && v != 0
+}
+
+// Make a 1 or 0 token for a truth value.
+func integerToken(pos Position, b bool) Token {
+	v := truth(b)
+	return Token{
+		ID:      TokenIntegerLiteral,
+		Value:   strconv.FormatInt(v, 10),
+		Pos:     pos,
+		End:     pos,
+		Literal: &Value{Kind: ValueInteger, Int: big.NewInt(v)},
+	}
+}
+
+// Expand the macros in a series of tokens. The macros in disabled are being
+// expanded already, and are left alone, so that a macro that refers to
+// itself does not expand forever.
+func (pp *preprocessor) expand(toks []Token, disabled map[string]bool) []Token {
+	out := []Token{}
+	for i := 0; i < len(toks); i++ {
+		tok := toks[i]
+		m := pp.macros[tok.Value]
+		if tok.ID != TokenIdentifier || m == nil || disabled[m.name] {
+			out = append(out, tok)
+			continue
+		}
+
+		var args [][]Token
+		if m.function {
+			var found, ok bool
+			args, i, found, ok = pp.arguments(m, toks, i)
+			if !found {
+				// A function-like macro's name without arguments is
+				// left alone.
+				out = append(out, tok)
+				continue
+			}
+			if !ok {
+				continue
+			}
+			for j := range args {
+				args[j] = pp.expand(args[j], disabled)
+			}
+		}
+
+		disabled[m.name] = true
+		out = append(out, pp.expand(pp.substitute(m, tok, args), disabled)...)
+		delete(disabled, m.name)
+	}
+	return out
+}
+
+// Read the arguments of a function-like macro, whose name is at toks[i].
+// Returns the arguments, and the index of the closing bracket. found is false
+// if the name is not followed by arguments, and ok is false if they are
+// malformed.
+func (pp *preprocessor) arguments(m *macro, toks []Token, i int) (args [][]Token, end int, found bool, ok bool) {
+	name := toks[i]
+	j := i + 1
+	for j < len(toks) && isSkippedToken(toks[j].ID) {
+		j++
+	}
+	if j >= len(toks) || toks[j].ID != TokenOpenBracket {
+		return nil, i, false, false
+	}
+
+	arg := []Token{}
+	depth := 0
+	for j++; j < len(toks); j++ {
+		tok := toks[j]
+		switch {
+		case isSkippedToken(tok.ID):
+			continue
+		case tok.ID == TokenOpenBracket:
+			depth++
+		case tok.ID == TokenCloseBracket && depth > 0:
+			depth--
+		case tok.ID == TokenCloseBracket:
+			args = append(args, arg)
+			if len(m.params) == 0 && len(args) == 1 && len(arg) == 0 {
+				// MACRO() has no arguments, rather than an empty one.
+				args = nil
+			}
+			if len(args) != len(m.params) {
+				pp.errorf(CodeMacroArguments, name.Pos, "macro %s takes %d arguments, but %d were given", m.name, len(m.params), len(args))
+				return nil, j, true, false
+			}
+			return args, j, true, true
+		case tok.ID == TokenComma && depth == 0:
+			args = append(args, arg)
+			arg = []Token{}
+			continue
+		}
+		arg = append(arg, tok)
+	}
+
+	pp.errorf(CodeMacroArguments, name.Pos, "unterminated arguments of macro %s", m.name)
+	return nil, len(toks) - 1, true, false
+}
+
+// Return the replacement of a macro used at the given token, with its
+// parameters replaced by their arguments. The replacement is placed where
+// the macro was used, though the tokens stay adjacent to each other as they
+// were in the definition.
+func (pp *preprocessor) substitute(m *macro, use Token, args [][]Token) []Token {
+	out := []Token{}
+	for j, tok := range m.body {
+		if i := paramIndex(m, tok); i >= 0 {
+			for k, arg := range args[i] {
+				// An argument is not pasted onto what comes before it.
+				if k == 0 {
+					arg.Adjacent = false
+				}
+				out = append(out, arg)
+			}
+			continue
+		}
+		tok.Pos, tok.End = use.Pos, use.End
+		if j == 0 {
+			tok.Adjacent = false
+		}
+		out = append(out, tok)
+	}
+	return out
+}
+
+// Return the index of the parameter a token of a macro's replacement names,
+// or -1 if it is not a parameter.
+func paramIndex(m *macro, tok Token) int {
+	if tok.ID != TokenIdentifier {
+		return -1
+	}
+	for i, p := range m.params {
+		if p == tok.Value {
+			return i
+		}
+	}
+	return -1
+}
+
+// An expression in an #if or #elif, being evaluated. Names that are left
+// after macros are expanded are 0, as in C.
+type ppExpr struct {
+	pp        *preprocessor
+	toks      []Token
+	i         int
+	directive string
+
+	// Where the directive is, for errors at the end of the expression
+	pos Position
+
+	// Set once an error has been reported, so that only one is
+	failed bool
+
+	// Above 0 while evaluating an operand that does not count, like the
+	// right hand side of 0 && X, where dividing by zero is not an error
+	skipping int
+}
+
+// Evaluate the whole expression.
+func (e *ppExpr) evaluate() (int64, bool) {
+	v := e.conditional()
+	if e.i < len(e.toks) {
+		e.fail("unexpected %s in #%s", tokenText(e.toks[e.i]), e.directive)
+	}
+	return v, !e.failed
+}
+
+// Report a syntax error at the current token.
+func (e *ppExpr) fail(format string, args ...interface{}) {
+	if e.failed {
+		return
+	}
+	e.failed = true
+	pos := e.pos
+	if e.i < len(e.toks) {
+		pos = e.toks[e.i].Pos
+	}
+	e.pp.errorf(CodeUnexpectedToken, pos, format, args...)
+}
+
+func (e *ppExpr) tok() Token {
+	if e.i < len(e.toks) {
+		return e.toks[e.i]
+	}
+	return Token{ID: TokenInvalid, Pos: e.pos}
+}
+
+// c ? a : b
+func (e *ppExpr) conditional() int64 {
+	c := e.binary(1)
+	if e.failed || e.tok().ID != TokenQuestion {
+		return c
+	}
+	e.i++
+
+	e.skip(c == 0)
+	a := e.conditional()
+	e.unskip(c == 0)
+
+	if e.tok().ID != TokenColon {
+		e.fail("expected : in #%s", e.directive)
+		return 0
+	}
+	e.i++
+
+	e.skip(c != 0)
+	b := e.conditional()
+	e.unskip(c != 0)
+
+	if c != 0 {
+		return a
+	}
+	return b
+}
+
+func (e *ppExpr) skip(b bool) {
+	if b {
+		e.skipping++
+	}
+}
+
+func (e *ppExpr) unskip(b bool) {
+	if b {
+		e.skipping--
+	}
+}
+
+// Return the binary operator at the current token, its precedence (higher
+// binds tighter, 0 if there is no operator) and how many tokens it takes.
+// Shifts are lexed as two < or > tokens.
+func (e *ppExpr) operator() (string, int, int) {
+	next := Token{ID: TokenInvalid}
+	if e.i+1 < len(e.toks) {
+		next = e.toks[e.i+1]
+	}
+
+	switch e.tok().ID {
+	case TokenLogicalOr:
+		return "||", 1, 1
+	case TokenLogicalAnd:
+		return "&&", 2, 1
+	case TokenPipe:
+		return "|", 3, 1
+	case TokenCaret:
+		return "^", 4, 1
+	case TokenAmpersand:
+		return "&", 5, 1
+	case TokenEqualEqual:
+		return "==", 6, 1
+	case TokenNotEqual:
+		return "!=", 6, 1
+	case TokenLessThan:
+		if next.ID == TokenLessThan && next.Adjacent {
+			return "<<", 8, 2
+		}
+		return "<", 7, 1
+	case TokenGreaterThan:
+		if next.ID == TokenGreaterThan && next.Adjacent {
+			return ">>", 8, 2
+		}
+		return ">", 7, 1
+	case TokenLessEqual:
+		return "<=", 7, 1
+	case TokenGreaterEqual:
+		return ">=", 7, 1
+	case TokenPlus:
+		return "+", 9, 1
+	case TokenMinus:
+		return "-", 9, 1
+	case TokenStar:
+		return "*", 10, 1
+	case TokenSlash:
+		return "/", 10, 1
+	case TokenPercent:
+		return "%", 10, 1
+	}
+	return "", 0, 0
+}
+
+// Evaluate binary operators of at least the given precedence.
+func (e *ppExpr) binary(minPrec int) int64 {
+	x := e.unary()
+	for !e.failed {
+		op, prec, n := e.operator()
+		if prec == 0 || prec < minPrec {
+			break
+		}
+		pos := e.tok().Pos
+		e.i += n
+
+		// Only the side of && and || that decides the result counts.
+		skip := (op == "&&" && x == 0) || (op == "||" && x != 0)
+		e.skip(skip)
+		y := e.binary(prec + 1)
+		e.unskip(skip)
+
+		x = e.apply(op, x, y, pos)
+	}
+	return x
+}
+
+func truth(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Apply a binary operator.
+func (e *ppExpr) apply(op string, x int64, y int64, pos Position) int64 {
+	switch op {
+	case "||":
+		return truth(x != 0 || y != 0)
+	case "&&":
+		return truth(x != 0 && y != 0)
+	case "|":
+		return x | y
+	case "^":
+		return x ^ y
+	case "&":
+		return x & y
+	case "==":
+		return truth(x == y)
+	case "!=":
+		return truth(x != y)
+	case "<":
+		return truth(x < y)
+	case ">":
+		return truth(x > y)
+	case "<=":
+		return truth(x <= y)
+	case ">=":
+		return truth(x >= y)
+	case "<<":
+		return x << uint64(y&63)
+	case ">>":
+		return x >> uint64(y&63)
+	case "+":
+		return x + y
+	case "-":
+		return x - y
+	case "*":
+		return x * y
+	}
+
+	// / and %
+	if y == 0 {
+		if e.skipping == 0 && !e.failed {
+			e.failed = true
+			e.pp.errorf(CodeDivisionByZero, pos, "division by zero in #%s", e.directive)
+		}
+		return 0
+	}
+	if op == "/" {
+		return x / y
+	}
+	return x % y
+}
+
+// Evaluate a value, with any unary operators before it.
+func (e *ppExpr) unary() int64 {
+	tok := e.tok()
+	switch tok.ID {
+	case TokenPlus:
+		e.i++
+		return e.unary()
+	case TokenMinus:
+		e.i++
+		return -e.unary()
+	case TokenTilde:
+		e.i++
+		return ^e.unary()
+	case TokenExclamation:
+		e.i++
+		return truth(e.unary() == 0)
+	case TokenOpenBracket:
+		e.i++
+		v := e.conditional()
+		if e.tok().ID != TokenCloseBracket {
+			e.fail("expected ) in #%s", e.directive)
+			return 0
+		}
+		e.i++
+		return v
+	case TokenIntegerLiteral:
+		e.i++
+		// Unsigned values too big for an int64 wrap, as they would in C.
+		return int64(tok.Literal.Int.Uint64())
+	case TokenIdentifier:
+		e.i++
+		return 0
+	case TokenInvalid:
+		e.fail("#%s expression ends early", e.directive)
+		return 0
+	}
+	e.fail("unexpected %s in #%s", tokenText(tok), e.directive)
+	return 0
+}
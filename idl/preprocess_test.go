@@ -0,0 +1,255 @@
+package idl
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// Preprocess src, and return the tokens that are left, other than newlines and
+// comments, written out and separated by spaces, along with the messages of
+// all diagnostics, including warnings.
+func preprocessString(t *testing.T, src string, opts ParseOptions) (string, string) {
+	t.Helper()
+	msgs := []string{}
+	opts.DiagnosticSink = func(d *Diagnostic) {
+		msgs = append(msgs, d.Msg)
+	}
+	toks, err := LexWithOptions("test.idl", []byte(src), opts)
+	if err != nil {
+		t.Fatalf("lexing %q: %s", src, err)
+	}
+	out, _ := Preprocess(toks, opts)
+	texts := []string{}
+	for _, tok := range withoutSkipped(out) {
+		texts = append(texts, tokenText(tok))
+	}
+	return strings.Join(texts, " "), strings.Join(msgs, "\n")
+}
+
+type preprocessTest struct {
+	src  string
+	want string
+	msgs string
+}
+
+func runPreprocessTests(t *testing.T, opts ParseOptions, tests []preprocessTest) {
+	t.Helper()
+	for _, test := range tests {
+		got, msgs := preprocessString(t, test.src, opts)
+		if got != test.want {
+			t.Errorf("%q: got\n\t%s\nwant\n\t%s", test.src, got, test.want)
+		}
+		if msgs != test.msgs {
+			t.Errorf("%q: got diagnostics %q, want %q", test.src, msgs, test.msgs)
+		}
+	}
+}
+
+func TestPreprocessMacros(t *testing.T) {
+	runPreprocessTests(t, ParseOptions{}, []preprocessTest{
+		{"#define N 10\nlong x[N];", "long x [ 10 ] ;", ""},
+		{"#define N\nN x", "x", ""},
+		{"#define A B\n#define B 2\nA", "2", ""},
+		{"#define A A + 1\nA", "A + 1", ""},
+		{"#define MAX(a, b) ((a) > (b) ? (a) : (b))\nMAX(1, 2)", "( ( 1 ) > ( 2 ) ? ( 1 ) : ( 2 ) )", ""},
+		{"#define F(x) x * 2\nF((1, 2))", "( 1 , 2 ) * 2", ""},
+		{"#define F(x) [x]\nF(\n1\n)", "[ 1 ]", ""},
+		{"#define F(x) x\nF", "F", ""},
+		{"#define F() 1\nF()", "1", ""},
+		{"#define LONG long \\\n long\nLONG x;", "long long x ;", ""},
+		{"#define N 1\n#undef N\nN", "N", ""},
+		{"#define N 1\n#define N 1\nN", "1", ""},
+		{"#define N 1\n#define N 2\nN", "2", "macro N is defined again differently"},
+		{"#define F(a, b) a\nF(1)", "", "macro F takes 2 arguments, but 1 were given"},
+		{"#define F(a) a\nF(1", "", "unterminated arguments of macro F"},
+		{"#define\n", "", "expected macro name after #define"},
+		{"#define F(1) x\n", "", "expected parameter name in #define of F"},
+		{"#bogus\nx", "x", "unexpected directive: bogus"},
+		{"#error stop here\n", "", "#error stop here"},
+		{"#warning careful\nx", "x", "#warning careful"},
+		{"#pragma keylist Foo id\n", "# pragma keylist Foo id", ""},
+	})
+
+	runPreprocessTests(t, ParseOptions{
+		Defines:   map[string]string{"N": "3", "ON": "1", "SQ(x)": "x * x", "GONE": "1"},
+		Undefines: []string{"GONE"},
+	}, []preprocessTest{
+		{"N SQ(2)", "3 2 * 2", ""},
+		{"#if ON\nyes\n#endif", "yes", ""},
+		{"#ifdef GONE\nyes\n#endif", "", ""},
+	})
+}
+
+func TestPreprocessShiftInMacro(t *testing.T) {
+	// The tokens of a macro's replacement take the position of its use, but
+	// a << in it is still a shift.
+	m, err := parseString(t, "#define FLAG (1 << 4)\nconst long X = FLAG;")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := m.Constants[0].Value.String(); got != "16" {
+		t.Errorf("got %s, want 16", got)
+	}
+}
+
+func TestPreprocessConditionals(t *testing.T) {
+	runPreprocessTests(t, ParseOptions{}, []preprocessTest{
+		{"#define A\n#ifdef A\nyes\n#else\nno\n#endif", "yes", ""},
+		{"#ifdef A\nyes\n#else\nno\n#endif", "no", ""},
+		{"#ifndef A\nyes\n#endif", "yes", ""},
+		{"#if 1 + 1 == 2\nyes\n#endif", "yes", ""},
+		{"#if 0\nyes\n#elif 2 > 1\nelif\n#else\nno\n#endif", "elif", ""},
+		{"#if 1\nyes\n#elif 1\nelif\n#endif", "yes", ""},
+		{"#define V 3\n#if V >= 2 && defined(V) && !defined W\nyes\n#endif", "yes", ""},
+		{"#if UNDEFINED\nyes\n#else\nno\n#endif", "no", ""},
+		{"#if (1 ? 2 : 3) == 2 && (7 % 4) == 3 && (1 << 3) == 8\nyes\n#endif", "yes", ""},
+		{"#if 0\n#if 1\na\n#else\nb\n#endif\n#else\nc\n#endif", "c", ""},
+		{"#if 0\n#bogus\n#error no\n#define N 1\n#endif\nN", "N", ""},
+
+		{"#if 1\nyes", "yes", "#if without #endif"},
+		{"#endif", "", "#endif without #if"},
+		{"#else", "", "#else without #if"},
+		{"#elif 1", "", "#elif without #if"},
+		{"#if 1\n#else\n#else\n#endif", "", "#else after #else"},
+		{"#if 1\n#else\n#elif 1\n#endif", "", "#elif after #else"},
+		{"#if 1 / 0\n#endif", "", "division by zero in #if"},
+		{"#if (1\n#endif", "", "expected ) in #if"},
+		{"#if 1 +\n#endif", "", "#if expression ends early"},
+		{"#if defined\n#endif", "", "expected macro name after defined"},
+	})
+}
+
+// Parse the file main.idl from fsys, with the given include paths. Returns the
+// names of the structs declared, and the diagnostics, including warnings.
+func parseFiles(t *testing.T, fsys fstest.MapFS, includePaths ...string) (string, []*Diagnostic) {
+	t.Helper()
+	diags := []*Diagnostic{}
+	opts := ParseOptions{
+		FS:             fsys,
+		IncludePaths:   includePaths,
+		DiagnosticSink: func(d *Diagnostic) { diags = append(diags, d) },
+	}
+	toks, _ := LexWithOptions("main.idl", fsys["main.idl"].Data, opts)
+	m, _ := ParseWithOptions(toks, opts)
+	names := []string{}
+	for _, s := range m.Structs {
+		names = append(names, s.Name)
+	}
+	return strings.Join(names, " "), diags
+}
+
+func TestPreprocessIncludes(t *testing.T) {
+	types := &fstest.MapFile{Data: []byte("struct T { long x; };\n")}
+	tests := []struct {
+		fsys         fstest.MapFS
+		includePaths []string
+		structs      string
+		diags        []string
+	}{
+		{
+			fsys: fstest.MapFS{
+				"main.idl":    {Data: []byte(`#include "types.idl"` + "\n#include <lib.idl>\nstruct M { T t; L l; };\n")},
+				"types.idl":   types,
+				"inc/lib.idl": {Data: []byte(`#include "types.idl"` + "\nstruct L { T t; };\n")},
+			},
+			includePaths: []string{"inc", "."},
+			structs:      "T L M",
+		},
+		{
+			// Quoted names are looked for next to the including file first.
+			fsys: fstest.MapFS{
+				"main.idl":      {Data: []byte(`#include "sub/a.idl"` + "\n")},
+				"sub/a.idl":     {Data: []byte(`#include "b.idl"` + "\n")},
+				"sub/b.idl":     {Data: []byte("struct B { long x; };\n")},
+				"b.idl":         {Data: []byte("struct Wrong { long x; };\n")},
+				"inc/other.idl": types,
+			},
+			structs: "B",
+		},
+		{
+			fsys: fstest.MapFS{
+				"main.idl":  {Data: []byte(`#include "types.idl"` + "\n" + `#include "./types.idl"` + "\nstruct M { T t; };\n")},
+				"types.idl": types,
+			},
+			structs: "T M",
+		},
+		{
+			fsys: fstest.MapFS{
+				"main.idl": {Data: []byte("#define USE_T\n" + `#include "cond.idl"` + "\n")},
+				"cond.idl": {Data: []byte("#ifdef USE_T\nstruct T { long x; };\n#else\nstruct U { long x; };\n#endif\n")},
+			},
+			structs: "T",
+		},
+		{
+			fsys: fstest.MapFS{
+				"main.idl": {Data: []byte(`#include "nope.idl"` + "\nstruct M { long x; };\n")},
+			},
+			structs: "M",
+			diags:   []string{"main.idl:1:10: cannot include nope.idl: file does not exist"},
+		},
+		{
+			// Angle brackets only search the include paths.
+			fsys: fstest.MapFS{
+				"main.idl":  {Data: []byte("#include <types.idl>\n")},
+				"types.idl": types,
+			},
+			diags: []string{"main.idl:1:10: cannot include types.idl: file does not exist"},
+		},
+		{
+			fsys: fstest.MapFS{
+				"main.idl": {Data: []byte(`#include "a.idl"` + "\nstruct M { long x; };\n")},
+				"a.idl":    {Data: []byte(`#include "b.idl"` + "\nstruct A { long x; };\n")},
+				"b.idl":    {Data: []byte(`#include "a.idl"` + "\nstruct B { long x; };\n")},
+			},
+			structs: "B A M",
+			diags:   []string{"b.idl:1:10: a.idl includes itself"},
+		},
+		{
+			fsys: fstest.MapFS{
+				"main.idl": {Data: []byte(`#include "bad.idl"` + "\nstruct M { long x; };\n")},
+				"bad.idl":  {Data: []byte("#if 1\nstruct B { long x };\n")},
+			},
+			structs: "B M",
+			diags: []string{
+				"bad.idl:1:1: #if without #endif",
+				"bad.idl:2:19: expected semicolon",
+			},
+		},
+	}
+
+	for i, test := range tests {
+		structs, diags := parseFiles(t, test.fsys, test.includePaths...)
+		if structs != test.structs {
+			t.Errorf("%d: got structs %q, want %q", i, structs, test.structs)
+		}
+		got := []string{}
+		for _, d := range diags {
+			got = append(got, d.Error())
+		}
+		if strings.Join(got, "\n") != strings.Join(test.diags, "\n") {
+			t.Errorf("%d: got diagnostics\n\t%s\nwant\n\t%s", i, strings.Join(got, "\n\t"), strings.Join(test.diags, "\n\t"))
+		}
+	}
+}
+
+func TestParseIncludeWithoutFS(t *testing.T) {
+	got := []*Diagnostic{}
+	opts := ParseOptions{DiagnosticSink: func(d *Diagnostic) { got = append(got, d) }}
+	_, err := parseStringWithOptions(t, `#include "types.idl"`+"\n#include <lib.idl>\n", opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := []string{
+		"test.idl:1:10: not including types.idl: there is no ParseOptions.FS to load it from",
+		"test.idl:2:10: not including lib.idl: there is no ParseOptions.FS to load it from",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d diagnostics, want %d", len(got), len(want))
+	}
+	for i, d := range got {
+		if d.Error() != want[i] || d.Severity != SeverityWarning || d.Code != CodeIncludeNotLoaded {
+			t.Errorf("got %s %s [%s], want warning %s [%s]", d.Severity, d, d.Code, want[i], CodeIncludeNotLoaded)
+		}
+	}
+}
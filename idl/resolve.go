@@ -0,0 +1,1045 @@
+package idl
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// What a name in the symbol table refers to.
+type symbolKind int
+
+const (
+	symbolModule symbolKind = iota
+	symbolConstant
+	symbolEnumerator
+	symbolTypeDef
+	symbolEnum
+	symbolStruct
+	symbolUnion
+	symbolInterface
+	symbolException
+	symbolBitmask
+	symbolBitset
+	symbolAnnotation
+)
+
+func (k symbolKind) String() string {
+	switch k {
+	case symbolModule:
+		return "module"
+	case symbolConstant:
+		return "constant"
+	case symbolEnumerator:
+		return "enumerator"
+	case symbolTypeDef:
+		return "typedef"
+	case symbolEnum:
+		return "enum"
+	case symbolStruct:
+		return "struct"
+	case symbolUnion:
+		return "union"
+	case symbolInterface:
+		return "interface"
+	case symbolException:
+		return "exception"
+	case symbolBitmask:
+		return "bitmask"
+	case symbolBitset:
+		return "bitset"
+	case symbolAnnotation:
+		return "annotation"
+	}
+	return "(wtf)"
+}
+
+// A symbol is a declaration that can be referred to by name.
+type symbol struct {
+	kind symbolKind
+
+	// The fully scoped name, without a leading ::, e.g. "Mod::FOO"
+	name string
+
+	// The scope the symbol is declared in, which names used in its
+	// definition are looked up from
+	scope string
+
+	// Where the symbol is declared
+	pos Position
+
+	constant *Constant
+	typeDef  *TypeDef
+	enum     *Enum
+	strct    *Struct
+	union    *Union
+	iface    *Interface
+	bitset   *Bitset
+
+	// For an annotation, its declaration, and the types of its parameters
+	// (nil where a type is not valid)
+	annotation *AnnotationDecl
+	paramTypes []*constType
+
+	// Set for a forward declaration, until the definition is found
+	forward *ForwardDecl
+
+	// The position of an enumerator in its enum, and the enum's symbol
+	index  int
+	parent *symbol
+
+	// Set while a constant's or enum's value is being computed, to catch
+	// values that are defined in terms of themselves.
+	resolving bool
+	resolved  bool
+
+	// The number of enumerators of an enum whose values are known
+	next int
+
+	// For an interface, the interfaces it inherits from. These are looked
+	// up the first time names are looked for in them.
+	bases      []*symbol
+	basesKnown bool
+}
+
+// The resolver runs once parsing is done. It finds out what the names used in
+// declarations refer to, and computes the values of constants.
+type resolver struct {
+	p       *parser
+	symbols map[string]*symbol
+
+	// Annotations declared with @annotation, which have names of their own,
+	// apart from other symbols
+	annotations map[string]*symbol
+
+	// Annotations that have been checked already. Members declared together,
+	// as in "@key long a, b;", share them.
+	checkedAnnotations map[*Annotation]bool
+
+	// Bounds that have been computed already. Types are copied around, but
+	// their bounds may be shared.
+	bounds map[*Bound]bool
+
+	// Map key types that have been checked already, which are shared in
+	// the same way
+	mapKeys map[*Type]bool
+
+	// Where named types have been looked up already. Members declared
+	// together, as in "Foo a, b;", share their type's position.
+	namedTypes map[Position]bool
+}
+
+// Resolve names and compute constant values for everything that was parsed.
+func (p *parser) resolve() {
+	r := &resolver{
+		p:                  p,
+		symbols:            make(map[string]*symbol),
+		annotations:        make(map[string]*symbol),
+		checkedAnnotations: make(map[*Annotation]bool),
+		bounds:             make(map[*Bound]bool),
+		mapKeys:            make(map[*Type]bool),
+		namedTypes:         make(map[Position]bool),
+	}
+	r.declareModule(p.rootModule, "")
+	forEachScope(p.rootModule, "", r.declareScope)
+	forEachScope(p.rootModule, "", r.linkScope)
+	forEachScope(p.rootModule, "", r.resolveScope)
+	r.resolveModule(p.rootModule, "")
+	r.applyKeylists()
+	r.checkModuleAnnotations(p.rootModule, "")
+	forEachScope(p.rootModule, "", r.checkScopeAnnotations)
+}
+
+// Report an error found while resolving.
+func (r *resolver) errorf(code DiagnosticCode, pos Position, format string, args ...interface{}) {
+	r.p.report(&Diagnostic{
+		Code:     code,
+		Severity: SeverityError,
+		Pos:      pos,
+		Msg:      fmt.Sprintf(format, args...),
+	})
+}
+
+// Join a name onto a scope.
+func scopedName(scope string, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "::" + name
+}
+
+// Return the scope enclosing the given one.
+func parentScope(scope string) string {
+	i := strings.LastIndex(scope, "::")
+	if i < 0 {
+		return ""
+	}
+	return scope[:i]
+}
+
+// Add a symbol to the table. A module may be opened more than once, and a
+// type may be declared forward before or after its definition, but otherwise a
+// name may only be declared once in a scope. The first declaration wins.
+func (r *resolver) declare(s *symbol) {
+	prev, ok := r.symbols[s.name]
+	switch {
+	case !ok:
+	case prev.kind == symbolModule && s.kind == symbolModule:
+		return
+	case prev.forward != nil && s.forward == nil:
+		// The definition replaces the forward declaration. linkScope
+		// checks that they agree.
+	case prev.forward != nil || s.forward != nil:
+		return
+	default:
+		first, again := prev, s
+		if s.pos.Filename == prev.pos.Filename && s.pos.Offset < prev.pos.Offset {
+			first, again = s, prev
+		}
+		r.p.report(&Diagnostic{
+			Code:     CodeRedefinition,
+			Severity: SeverityError,
+			Pos:      again.pos,
+			Msg:      fmt.Sprintf("%s %s is already declared", again.kind, again.name),
+			Related: []RelatedInformation{{
+				Pos: first.pos,
+				Msg: fmt.Sprintf("%s %s declared here", first.kind, first.name),
+			}},
+		})
+		if first == prev {
+			return
+		}
+	}
+	r.symbols[s.name] = s
+}
+
+// Call fn for the declarations in a module, and for all those nested inside
+// it: in its modules, interfaces, structs and unions.
+func forEachScope(m *Module, scope string, fn func(d *Declarations, scope string)) {
+	forEachNestedScope(&m.Declarations, scope, fn)
+	for i := range m.Modules {
+		forEachScope(&m.Modules[i], scopedName(scope, m.Modules[i].Name), fn)
+	}
+	for i := range m.Interfaces {
+		forEachNestedScope(&m.Interfaces[i].Declarations, scopedName(scope, m.Interfaces[i].Name), fn)
+	}
+}
+
+func forEachNestedScope(d *Declarations, scope string, fn func(d *Declarations, scope string)) {
+	fn(d, scope)
+	for i := range d.Structs {
+		forEachNestedScope(&d.Structs[i].Declarations, scopedName(scope, d.Structs[i].Name), fn)
+	}
+	for i := range d.Unions {
+		forEachNestedScope(&d.Unions[i].Declarations, scopedName(scope, d.Unions[i].Name), fn)
+	}
+}
+
+// Add the modules and interfaces in a module, and the modules inside it, to
+// the symbol table.
+func (r *resolver) declareModule(m *Module, scope string) {
+	for i := range m.Modules {
+		mod := &m.Modules[i]
+		name := scopedName(scope, mod.Name)
+		r.declare(&symbol{kind: symbolModule, name: name, scope: scope, pos: mod.Pos})
+		r.declareModule(mod, name)
+	}
+	for i := range m.Interfaces {
+		iface := &m.Interfaces[i]
+		r.declare(&symbol{kind: symbolInterface, name: scopedName(scope, iface.Name), scope: scope, pos: iface.Pos, iface: iface})
+	}
+}
+
+// Add the types and constants declared in a scope to the symbol table.
+func (r *resolver) declareScope(d *Declarations, scope string) {
+	for i := range d.Constants {
+		c := &d.Constants[i]
+		r.declare(&symbol{kind: symbolConstant, name: scopedName(scope, c.Name), scope: scope, pos: c.Pos, constant: c})
+	}
+	for i := range d.TypeDefs {
+		t := &d.TypeDefs[i]
+		r.declare(&symbol{kind: symbolTypeDef, name: scopedName(scope, t.Name), scope: scope, pos: t.Pos, typeDef: t})
+	}
+	for i := range d.Enums {
+		e := &d.Enums[i]
+		es := &symbol{kind: symbolEnum, name: scopedName(scope, e.Name), scope: scope, pos: e.Pos, enum: e}
+		r.declare(es)
+
+		// Enumerators belong to the scope enclosing the enum.
+		for j, member := range e.Members {
+			r.declare(&symbol{kind: symbolEnumerator, name: scopedName(scope, member.Name), scope: scope, pos: member.Pos, enum: e, index: j, parent: es})
+		}
+	}
+	for i := range d.ForwardDecls {
+		fd := &d.ForwardDecls[i]
+		r.declare(&symbol{kind: forwardSymbolKind[fd.Kind], name: scopedName(scope, fd.Name), scope: scope, pos: fd.Pos, forward: fd})
+	}
+	for i := range d.Structs {
+		s := &d.Structs[i]
+		r.declare(&symbol{kind: symbolStruct, name: scopedName(scope, s.Name), scope: scope, pos: s.Pos, strct: s})
+	}
+	for i := range d.Unions {
+		u := &d.Unions[i]
+		r.declare(&symbol{kind: symbolUnion, name: scopedName(scope, u.Name), scope: scope, pos: u.Pos, union: u})
+	}
+	for i := range d.Exceptions {
+		e := &d.Exceptions[i]
+		r.declare(&symbol{kind: symbolException, name: scopedName(scope, e.Name), scope: scope, pos: e.Pos})
+	}
+	for i := range d.Bitmasks {
+		b := &d.Bitmasks[i]
+		r.declare(&symbol{kind: symbolBitmask, name: scopedName(scope, b.Name), scope: scope, pos: b.Pos})
+	}
+	for i := range d.Bitsets {
+		b := &d.Bitsets[i]
+		r.declare(&symbol{kind: symbolBitset, name: scopedName(scope, b.Name), scope: scope, pos: b.Pos, bitset: b})
+	}
+	for i := range d.AnnotationDecls {
+		a := &d.AnnotationDecls[i]
+		name := scopedName(scope, a.Name)
+		if _, ok := r.annotations[name]; !ok {
+			r.annotations[name] = &symbol{kind: symbolAnnotation, name: name, scope: scope, pos: a.Pos, annotation: a}
+		}
+	}
+}
+
+// The kind of symbol a forward declaration declares.
+var forwardSymbolKind = map[ForwardKind]symbolKind{
+	ForwardStruct:    symbolStruct,
+	ForwardUnion:     symbolUnion,
+	ForwardInterface: symbolInterface,
+}
+
+// Link the forward declarations in a scope to their definitions.
+func (r *resolver) linkScope(d *Declarations, scope string) {
+	for i := range d.ForwardDecls {
+		fd := &d.ForwardDecls[i]
+		s := r.symbols[scopedName(scope, fd.Name)]
+		switch {
+		case s.forward != nil:
+			r.errorf(CodeUndefinedType, fd.Pos, "%s %s is declared, but never defined", fd.Kind, fd.Name)
+		case s.kind != forwardSymbolKind[fd.Kind]:
+			r.p.report(&Diagnostic{
+				Code:     CodeTypeMismatch,
+				Severity: SeverityError,
+				Pos:      fd.Pos,
+				Msg:      fmt.Sprintf("%s is declared as %s, but defined as %s", fd.Name, fd.Kind, s.kind),
+				Related: []RelatedInformation{{
+					Pos: s.pos,
+					Msg: fd.Name + " defined here",
+				}},
+			})
+		default:
+			fd.Struct = s.strct
+			fd.Union = s.union
+			fd.Interface = s.iface
+		}
+	}
+}
+
+// Find what a name used in the given scope refers to. A name starting with ::
+// is looked up from the outermost scope; otherwise, the innermost scope with
+// a match wins. Names declared in the interfaces an interface inherits from
+// are found in its scope as well. Returns nil if there is no such name.
+func (r *resolver) lookup(name string, scope string) *symbol {
+	if strings.HasPrefix(name, "::") {
+		return r.lookupScoped(name[2:], "")
+	}
+
+	for {
+		if s := r.lookupScoped(name, scope); s != nil {
+			return s
+		}
+		if scope == "" {
+			return nil
+		}
+		scope = parentScope(scope)
+	}
+}
+
+// Find a name, which may be scoped itself, declared inside the given scope.
+func (r *resolver) lookupScoped(name string, scope string) *symbol {
+	var s *symbol
+	for _, part := range strings.Split(name, "::") {
+		s = r.lookupMember(part, scope, map[*symbol]bool{})
+		if s == nil {
+			return nil
+		}
+		scope = s.name
+	}
+	return s
+}
+
+// Find a name declared directly in the given scope or, if the scope is an
+// interface, in the interfaces it inherits from.
+func (r *resolver) lookupMember(name string, scope string, seen map[*symbol]bool) *symbol {
+	if s, ok := r.symbols[scopedName(scope, name)]; ok {
+		return s
+	}
+	iface, ok := r.symbols[scope]
+	if !ok || iface.iface == nil || seen[iface] {
+		return nil
+	}
+	seen[iface] = true
+	for _, base := range r.interfaceBases(iface) {
+		if s := r.lookupMember(name, base.name, seen); s != nil {
+			return s
+		}
+	}
+	return nil
+}
+
+// Return the interfaces an interface inherits from. Names that are not
+// interfaces are left out.
+func (r *resolver) interfaceBases(s *symbol) []*symbol {
+	if s.basesKnown {
+		return s.bases
+	}
+	// Set first, so that an interface named in terms of its own bases does
+	// not look itself up forever.
+	s.basesKnown = true
+	for _, name := range s.iface.Inherits {
+		if base := r.lookup(name, s.scope); base != nil && base.iface != nil {
+			s.bases = append(s.bases, base)
+		}
+	}
+	return s.bases
+}
+
+// Like lookup, but in the given symbol table, and without looking in the
+// interfaces an interface inherits from.
+func lookupIn(symbols map[string]*symbol, name string, scope string) *symbol {
+	if strings.HasPrefix(name, "::") {
+		return symbols[name[2:]]
+	}
+
+	for {
+		if s, ok := symbols[scopedName(scope, name)]; ok {
+			return s
+		}
+		if scope == "" {
+			return nil
+		}
+		scope = parentScope(scope)
+	}
+}
+
+// Compute the bounds of the types used by the methods and attributes of the
+// interfaces in a module, and the modules inside it, and check the exceptions
+// they raise.
+func (r *resolver) resolveModule(m *Module, scope string) {
+	for i := range m.Modules {
+		r.resolveModule(&m.Modules[i], scopedName(scope, m.Modules[i].Name))
+	}
+	for i := range m.Interfaces {
+		iface := &m.Interfaces[i]
+		ifaceScope := scopedName(scope, iface.Name)
+		for j := range iface.Methods {
+			method := &iface.Methods[j]
+			r.resolveType(&method.ReturnValue, ifaceScope)
+			for k := range method.Parameters {
+				r.resolveType(&method.Parameters[k].Type, ifaceScope)
+			}
+			r.resolveRaises(method.Raises, method.Pos, ifaceScope)
+			if method.Oneway {
+				r.checkOneway(method)
+			}
+		}
+		for j := range iface.Attributes {
+			attr := &iface.Attributes[j]
+			r.resolveType(&attr.Type, ifaceScope)
+			r.resolveRaises(attr.GetRaises, attr.Pos, ifaceScope)
+			r.resolveRaises(attr.SetRaises, attr.Pos, ifaceScope)
+		}
+	}
+}
+
+// Check that the names in a raises clause are exceptions.
+func (r *resolver) resolveRaises(raises []string, pos Position, scope string) {
+	for _, name := range raises {
+		s := r.lookup(name, scope)
+		if s == nil {
+			r.errorf(CodeUnknownName, pos, "unknown exception %s", name)
+		} else if s.kind != symbolException {
+			r.errorf(CodeTypeMismatch, pos, "%s %s is not an exception", s.kind, name)
+		}
+	}
+}
+
+// A oneway method does not send anything back to its caller.
+func (r *resolver) checkOneway(m *Method) {
+	if m.ReturnValue.Name != "void" {
+		r.errorf(CodeTypeMismatch, m.Pos, "oneway method %s must return void", m.Name)
+	}
+	for _, param := range m.Parameters {
+		if param.Direction != DirectionIn {
+			r.errorf(CodeTypeMismatch, m.Pos, "oneway method %s cannot have %s parameters", m.Name, param.Direction)
+			break
+		}
+	}
+	if len(m.Raises) > 0 {
+		r.errorf(CodeTypeMismatch, m.Pos, "oneway method %s cannot raise exceptions", m.Name)
+	}
+}
+
+// Compute the values of all constants, enumerators, union labels and bounds
+// declared in a scope.
+func (r *resolver) resolveScope(d *Declarations, scope string) {
+	for _, c := range d.Constants {
+		s := r.symbols[scopedName(scope, c.Name)]
+		if s != nil && s.constant != nil {
+			r.resolveConstant(s)
+		}
+	}
+	for _, e := range d.Enums {
+		s := r.symbols[scopedName(scope, e.Name)]
+		if s != nil && s.kind == symbolEnum {
+			r.resolveEnum(s)
+		}
+	}
+	for i := range d.Bitmasks {
+		r.resolveBitmask(&d.Bitmasks[i], scope)
+	}
+	for _, b := range d.Bitsets {
+		s := r.symbols[scopedName(scope, b.Name)]
+		if s != nil && s.kind == symbolBitset {
+			r.resolveBitset(s)
+		}
+	}
+	for i := range d.TypeDefs {
+		r.resolveType(&d.TypeDefs[i].Type, scope)
+	}
+	for i := range d.Structs {
+		s := &d.Structs[i]
+		for j := range s.Members {
+			r.resolveType(&s.Members[j].Type, scopedName(scope, s.Name))
+		}
+	}
+	for i := range d.Exceptions {
+		e := &d.Exceptions[i]
+		for j := range e.Members {
+			r.resolveType(&e.Members[j].Type, scopedName(scope, e.Name))
+		}
+	}
+	for i := range d.Unions {
+		u := &d.Unions[i]
+		unionScope := scopedName(scope, u.Name)
+		r.resolveUnion(u, unionScope)
+		for j := range u.Members {
+			r.resolveType(&u.Members[j].MemberType, unionScope)
+		}
+	}
+}
+
+// Check that the names in a type used in the given scope are types, and
+// compute its array dimensions and bounds.
+func (r *resolver) resolveType(t *Type, scope string) {
+	if t.Kind == TypeNamed && t.Name != "" {
+		r.resolveTypeName(t, scope)
+	}
+	for i := range t.Dimensions {
+		r.resolveBound(&t.Dimensions[i], scope)
+	}
+	if t.Scale != nil {
+		r.resolveFixed(t, scope)
+	} else if t.Bound != nil {
+		r.resolveBound(t.Bound, scope)
+	}
+	for i := range t.TemplateParameters {
+		r.resolveType(&t.TemplateParameters[i], scope)
+	}
+	if t.Kind == TypeMap {
+		r.checkMapKey(&t.TemplateParameters[0], scope)
+	}
+}
+
+// Check that the name of a type used in the given scope refers to a type.
+func (r *resolver) resolveTypeName(t *Type, scope string) {
+	if r.namedTypes[t.Pos] {
+		return
+	}
+	r.namedTypes[t.Pos] = true
+
+	s := r.lookup(t.Name, scope)
+	if s == nil {
+		r.errorf(CodeUnknownName, t.Pos, "unknown type %s", t.Name)
+		return
+	}
+	switch s.kind {
+	case symbolModule, symbolConstant, symbolEnumerator, symbolException:
+		r.errorf(CodeTypeMismatch, t.Pos, "%s %s is not a type", s.kind, t.Name)
+	}
+}
+
+// Check that a type used in the given scope can be the key of a map: an
+// integer or a string, possibly by way of typedefs.
+func (r *resolver) checkMapKey(t *Type, scope string) {
+	if r.mapKeys[t] {
+		return
+	}
+	r.mapKeys[t] = true
+
+	key := *t
+	seen := map[string]bool{}
+	for len(key.Dimensions) == 0 {
+		if key.Primitive.IsInteger() || key.Primitive == PrimitiveString || key.Primitive == PrimitiveWString {
+			return
+		}
+		if key.Kind != TypeNamed || seen[key.Name] {
+			break
+		}
+		seen[key.Name] = true
+
+		// Unknown names are not a problem with the map.
+		s := r.lookup(key.Name, scope)
+		if s == nil {
+			return
+		}
+		if s.kind != symbolTypeDef {
+			break
+		}
+		key = s.typeDef.Type
+		scope = s.scope
+	}
+
+	r.errorf(CodeTypeMismatch, t.Pos, "%s cannot be the key of a map, which must be an integer or string type", t)
+}
+
+// The type of array dimensions and bounds.
+var boundType = constTypes["unsigned long"]
+
+// Compute the value of an array dimension or bound, which must be a positive
+// integer.
+func (r *resolver) resolveBound(b *Bound, scope string) {
+	if r.bounds[b] {
+		return
+	}
+	r.bounds[b] = true
+
+	v, ok := r.evalBound(b, scope)
+	if !ok {
+		return
+	}
+	if v == 0 {
+		r.errorf(CodeInvalidArraySize, b.Expr.Pos(), "size must be positive: %s", b.Expr)
+		return
+	}
+	b.Value = v
+}
+
+// Compute the digits and scale of a fixed point type, as in fixed<10, 2>.
+// Unlike other bounds, the scale can be 0.
+func (r *resolver) resolveFixed(t *Type, scope string) {
+	if t.Bound == nil || r.bounds[t.Scale] {
+		return
+	}
+	r.bounds[t.Scale] = true
+
+	r.resolveBound(t.Bound, scope)
+	scale, ok := r.evalBound(t.Scale, scope)
+	if !ok || t.Bound.Value == 0 {
+		return
+	}
+	t.Scale.Value = scale
+
+	if t.Bound.Value > maxFixedDigits {
+		r.errorf(CodeOutOfRange, t.Bound.Expr.Pos(), "fixed point type %s has more than %d digits", t, maxFixedDigits)
+	} else if scale > t.Bound.Value {
+		r.errorf(CodeOutOfRange, t.Scale.Expr.Pos(), "fixed point type %s has a scale larger than its digits", t)
+	}
+}
+
+func (r *resolver) evalBound(b *Bound, scope string) (int, bool) {
+	v, ok := r.eval(b.Expr, scope, boundType)
+	if ok {
+		v, ok = r.convert(v, boundType, b.Expr.Pos())
+	}
+	if !ok {
+		return 0, false
+	}
+	return int(v.Int.Int64()), true
+}
+
+// Compute the value of a constant, if that hasn't been done already. Returns
+// false if it has no value.
+func (r *resolver) resolveConstant(s *symbol) bool {
+	c := s.constant
+	if s.resolved {
+		return c.Value.Kind != ValueInvalid
+	}
+
+	s.resolving = true
+	t, ok := r.constType(c.Type, s.scope)
+	if ok {
+		var v Value
+		v, ok = r.eval(c.Expr, s.scope, t)
+		if ok {
+			v, ok = r.convert(v, t, c.Expr.Pos())
+		}
+		if ok {
+			c.Value = v
+		}
+	}
+	s.resolving = false
+	s.resolved = true
+	return ok
+}
+
+// Compute the values of an enum's enumerators, and check that they fit in the
+// enum and are unique.
+func (r *resolver) resolveEnum(s *symbol) {
+	if s.resolved || s.resolving {
+		return
+	}
+	s.resolving = true
+	defer func() {
+		s.resolving = false
+		s.resolved = true
+	}()
+
+	e := s.enum
+	t := constTypes["long"]
+	if e.BitBound != nil {
+		r.resolveBound(e.BitBound, s.scope)
+		if e.BitBound.Value > 32 {
+			r.errorf(CodeOutOfRange, e.BitBound.Expr.Pos(), "@bit_bound of an enum must be between 1 and 32")
+		} else if e.BitBound.Value > 0 {
+			t = integerType(fmt.Sprintf("@bit_bound(%d)", e.BitBound.Value), uint(e.BitBound.Value), false)
+		}
+	}
+	t.name = "enum " + e.Name
+
+	seen := make(map[int]int)
+	next := big.NewInt(0)
+	for i := range e.Members {
+		m := &e.Members[i]
+		if m.Expr != nil {
+			v, ok := r.eval(m.Expr, s.scope, t)
+			if ok {
+				v, ok = r.convert(v, t, m.Expr.Pos())
+			}
+			if ok {
+				next = v.Int
+			}
+		} else if next.Cmp(t.max) > 0 {
+			r.errorf(CodeOutOfRange, m.Pos, "value %s of enumerator %s out of range for %s", next, m.Name, t.name)
+		}
+
+		m.Value = int(next.Int64())
+		if j, ok := seen[m.Value]; ok {
+			r.duplicate(m.Pos, e.Members[j].Pos, "enumerator %s has the same value (%d) as %s", m.Name, m.Value, e.Members[j].Name)
+		} else {
+			seen[m.Value] = i
+		}
+
+		next = new(big.Int).Add(next, big.NewInt(1))
+		s.next = i + 1
+	}
+}
+
+// Compute the positions of the flags in a bitmask declared in the given scope.
+func (r *resolver) resolveBitmask(b *Bitmask, scope string) {
+	bits := 32
+	if b.BitBound != nil {
+		r.resolveBound(b.BitBound, scope)
+		if b.BitBound.Value > 64 {
+			r.errorf(CodeOutOfRange, b.BitBound.Expr.Pos(), "@bit_bound of a bitmask must be between 1 and 64")
+		} else if b.BitBound.Value > 0 {
+			bits = b.BitBound.Value
+		}
+	}
+	t := integerType("", 16, false)
+	t.max = big.NewInt(int64(bits - 1))
+	t.name = fmt.Sprintf("bitmask %s (positions 0 to %d)", b.Name, bits-1)
+
+	seen := make(map[int]int)
+	next := big.NewInt(0)
+	for i := range b.Members {
+		m := &b.Members[i]
+		if m.Expr != nil {
+			v, ok := r.eval(m.Expr, scope, t)
+			if ok {
+				v, ok = r.convert(v, t, m.Expr.Pos())
+			}
+			if ok {
+				next = v.Int
+			}
+		} else if next.Cmp(t.max) > 0 {
+			r.errorf(CodeOutOfRange, m.Pos, "position %s of flag %s out of range for %s", next, m.Name, t.name)
+		}
+
+		m.Position = int(next.Int64())
+		if j, ok := seen[m.Position]; ok {
+			r.duplicate(m.Pos, b.Members[j].Pos, "flag %s has the same position (%d) as %s", m.Name, m.Position, b.Members[j].Name)
+		} else {
+			seen[m.Position] = i
+		}
+
+		next = new(big.Int).Add(next, big.NewInt(1))
+	}
+}
+
+// Compute the widths and offsets of the fields of a bitset, after those of the
+// bitset it extends.
+func (r *resolver) resolveBitset(s *symbol) {
+	b := s.bitset
+	if s.resolved {
+		return
+	}
+	if s.resolving {
+		r.errorf(CodeRecursiveDefinition, b.Pos, "bitset %s extends itself", b.Name)
+		return
+	}
+	s.resolving = true
+	defer func() {
+		s.resolving = false
+		s.resolved = true
+	}()
+
+	offset := 0
+	if b.Inherits != "" {
+		base := r.lookup(b.Inherits, s.scope)
+		switch {
+		case base == nil:
+			r.errorf(CodeUnknownName, b.Pos, "unknown bitset %s", b.Inherits)
+		case base.kind != symbolBitset:
+			r.errorf(CodeTypeMismatch, b.Pos, "%s %s is not a bitset", base.kind, b.Inherits)
+		default:
+			if !base.resolving {
+				b.Base = base.bitset
+			}
+			r.resolveBitset(base)
+			offset = base.bitset.Bits
+		}
+	}
+
+	tooWide := false
+	for i := range b.Fields {
+		f := &b.Fields[i]
+		r.resolveBound(&f.Width, s.scope)
+		f.Offset = offset
+		offset += f.Width.Value
+
+		switch {
+		case f.Width.Value == 0:
+			// Already reported.
+		case f.Width.Value > 64:
+			r.errorf(CodeOutOfRange, f.Width.Expr.Pos(), "bitfield width must be between 1 and 64")
+			tooWide = true
+		case f.Type.Name == "":
+			f.Type = bitfieldType(f.Width.Value, f.Pos)
+		case f.Type.Primitive == PrimitiveBoolean:
+			if f.Width.Value != 1 {
+				r.errorf(CodeOutOfRange, f.Width.Expr.Pos(), "a boolean bitfield must be 1 bit wide")
+			}
+		case f.Type.Primitive.IsInteger():
+			t := constTypes[f.Type.Name]
+			bits := t.max.BitLen()
+			if t.min.Sign() < 0 {
+				bits++
+			}
+			if f.Width.Value > bits {
+				r.errorf(CodeOutOfRange, f.Width.Expr.Pos(), "bitfield of %d bits does not fit in %s", f.Width.Value, f.Type)
+			}
+		default:
+			r.errorf(CodeTypeMismatch, f.Type.Pos, "%s cannot hold a bitfield, which must be boolean, octet or an integer type", f.Type)
+		}
+	}
+
+	if offset > 64 && !tooWide {
+		r.errorf(CodeOutOfRange, b.Pos, "bitset %s has %d bits, more than 64", b.Name, offset)
+	}
+	b.Bits = offset
+}
+
+// The type that holds a bitfield of the given width, when it is not given.
+func bitfieldType(width int, pos Position) Type {
+	k := PrimitiveUnsignedLongLong
+	switch {
+	case width == 1:
+		k = PrimitiveBoolean
+	case width <= 8:
+		k = PrimitiveOctet
+	case width <= 16:
+		k = PrimitiveUnsignedShort
+	case width <= 32:
+		k = PrimitiveUnsignedLong
+	}
+	return Type{Name: k.String(), Kind: TypePrimitive, Primitive: k, Pos: pos}
+}
+
+// Compute the case labels of a union, and check that they suit the
+// discriminant and are unique.
+func (r *resolver) resolveUnion(u *Union, scope string) {
+	t, ok := r.constType(u.Discriminant, scope)
+	if !ok {
+		return
+	}
+	switch t.kind {
+	case ValueInteger, ValueChar, ValueWChar, ValueBoolean, ValueEnumerator:
+	default:
+		r.errorf(CodeTypeMismatch, u.Discriminant.Pos, "%s cannot be the discriminant of a union", t.name)
+		return
+	}
+
+	seen := make(map[string]Position)
+	var defaultPos *Position
+	for i := range u.Members {
+		m := &u.Members[i]
+		if m.IsDefault {
+			if defaultPos != nil {
+				r.duplicate(m.Pos, *defaultPos, "union %s has more than one default member", u.Name)
+			}
+			defaultPos = &m.Pos
+		}
+
+		for j := range m.Labels {
+			label := &m.Labels[j]
+			v, ok := r.eval(label.Expr, scope, t)
+			if ok {
+				v, ok = r.convert(v, t, label.Expr.Pos())
+			}
+			if !ok {
+				continue
+			}
+			label.Value = v
+
+			if pos, ok := seen[v.String()]; ok {
+				r.duplicate(label.Expr.Pos(), pos, "duplicate case label %s in union %s", v, u.Name)
+				continue
+			}
+			seen[v.String()] = label.Expr.Pos()
+		}
+	}
+}
+
+// Report a value that should be unique, but is also given at prev.
+func (r *resolver) duplicate(pos Position, prev Position, format string, args ...interface{}) {
+	r.p.report(&Diagnostic{
+		Code:     CodeDuplicateValue,
+		Severity: SeverityError,
+		Pos:      pos,
+		Msg:      fmt.Sprintf(format, args...),
+		Related: []RelatedInformation{{
+			Pos: prev,
+			Msg: "previously given here",
+		}},
+	})
+}
+
+// constType describes the type of a constant, as far as computing its value
+// goes.
+type constType struct {
+	// The name of the type, as written
+	name string
+
+	// The kind of value the type holds
+	kind ValueKind
+
+	// The range of an integer type
+	min *big.Int
+	max *big.Int
+
+	// Whether a floating point type is single precision
+	float32 bool
+
+	// The enum, for an enum type
+	enum *Enum
+
+	// The bound of a bounded string type, or 0
+	bound int
+
+	// Whether any value will do, as for an annotation parameter of type any
+	any bool
+}
+
+func integerType(name string, bits uint, signed bool) constType {
+	t := constType{name: name, kind: ValueInteger}
+	if signed {
+		t.max = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), bits-1), big.NewInt(1))
+		t.min = new(big.Int).Sub(new(big.Int).Neg(t.max), big.NewInt(1))
+	} else {
+		t.max = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), bits), big.NewInt(1))
+		t.min = new(big.Int)
+	}
+	return t
+}
+
+// The types a constant can be declared with, by name.
+var constTypes = map[string]constType{
+	"short":              integerType("short", 16, true),
+	"long":               integerType("long", 32, true),
+	"long long":          integerType("long long", 64, true),
+	"unsigned short":     integerType("unsigned short", 16, false),
+	"unsigned long":      integerType("unsigned long", 32, false),
+	"unsigned long long": integerType("unsigned long long", 64, false),
+	"int8":               integerType("int8", 8, true),
+	"int16":              integerType("int16", 16, true),
+	"int32":              integerType("int32", 32, true),
+	"int64":              integerType("int64", 64, true),
+	"uint8":              integerType("uint8", 8, false),
+	"uint16":             integerType("uint16", 16, false),
+	"uint32":             integerType("uint32", 32, false),
+	"uint64":             integerType("uint64", 64, false),
+	"octet":              integerType("octet", 8, false),
+	"float":              {name: "float", kind: ValueFloat, float32: true},
+	"double":             {name: "double", kind: ValueFloat},
+	"long double":        {name: "long double", kind: ValueFloat},
+	"fixed":              {name: "fixed", kind: ValueFixed},
+	"char":               {name: "char", kind: ValueChar},
+	"wchar":              {name: "wchar", kind: ValueWChar},
+	"boolean":            {name: "boolean", kind: ValueBoolean},
+	"string":             {name: "string", kind: ValueString},
+	"wstring":            {name: "wstring", kind: ValueWString},
+}
+
+// Intermediate results of integer expressions must fit in the widest IDL
+// integer types.
+var (
+	minInteger = constTypes["long long"].min
+	maxInteger = constTypes["unsigned long long"].max
+)
+
+// Find the type of a constant declared with type t in the given scope,
+// following typedefs.
+func (r *resolver) constType(t Type, scope string) (constType, bool) {
+	name := t.String()
+	seen := map[string]bool{}
+	for {
+		if seen[t.Name] {
+			r.errorf(CodeRecursiveDefinition, t.Pos, "type %s is defined in terms of itself", name)
+			return constType{}, false
+		}
+		seen[t.Name] = true
+
+		if len(t.Dimensions) > 0 {
+			r.errorf(CodeTypeMismatch, t.Pos, "array type %s cannot be the type of a constant", name)
+			return constType{}, false
+		}
+		if ct, ok := constTypes[t.Name]; ok {
+			ct.name = name
+			if t.Bound != nil {
+				r.resolveBound(t.Bound, scope)
+				ct.bound = t.Bound.Value
+			}
+			return ct, true
+		}
+
+		s := r.lookup(t.Name, scope)
+		if s == nil {
+			r.errorf(CodeUnknownName, t.Pos, "unknown type %s", t.Name)
+			return constType{}, false
+		}
+
+		switch s.kind {
+		case symbolTypeDef:
+			t = s.typeDef.Type
+			scope = s.scope
+		case symbolEnum:
+			return constType{name: name, kind: ValueEnumerator, enum: s.enum}, true
+		default:
+			r.errorf(CodeTypeMismatch, t.Pos, "%s %s cannot be the type of a constant", s.kind, t.Name)
+			return constType{}, false
+		}
+	}
+}
@@ -0,0 +1,546 @@
+package idl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestEnumValues(t *testing.T) {
+	tests := []struct {
+		src    string
+		values string
+		err    string
+	}{
+		{"enum E { A, B, C };", "A=0 B=1 C=2", ""},
+		{"enum E { A, @value(5) B, C };", "A=0 B=5 C=6", ""},
+		{"enum E { A = 3, B, C = 1 };", "A=3 B=4 C=1", ""},
+		{"const long N = 4; enum E { A = N * 2, B };", "A=8 B=9", ""},
+		{"enum E { A = -1, B };", "A=-1 B=0", ""},
+		{"@bit_bound(8) enum E { A = 255 };", "A=255", ""},
+		{"enum E { A = 2147483647 };", "A=2147483647", ""},
+
+		{"enum E { A, B = 0 };", "A=0 B=0", "enumerator B has the same value (0) as A"},
+		{"enum E { A = 1, B, C = 2 };", "A=1 B=2 C=2", "enumerator C has the same value (2) as B"},
+		{"enum E { A = 2147483648 };", "A=0", "value 2147483648 out of range for enum E"},
+		{"enum E { A = 2147483647, B };", "A=2147483647 B=2147483648", "value 2147483648 of enumerator B out of range for enum E"},
+		{"@bit_bound(8) enum E { A = 256 };", "A=0", "value 256 out of range for enum E"},
+		{"@bit_bound(8) enum E { A = -1 };", "A=0", "value -1 out of range for enum E"},
+		{"@bit_bound(8) enum E { A = 255, B };", "A=255 B=256", "value 256 of enumerator B out of range for enum E"},
+		{"@bit_bound(33) enum E { A };", "A=0", "@bit_bound of an enum must be between 1 and 32"},
+		{"enum E { @value(1) A = 1 };", "", "enumerator A has both @value and a value"},
+		{`enum E { A = "x" };`, "A=0", `cannot use string value "x" as enum E`},
+		{"enum E { A = 2, B = A + 1 };", "A=2 B=3", "mismatched types enumerator and integer for operator +"},
+		{"enum E { A = B, B };", "A=0 B=1", "enumerator B is used before its value is known"},
+	}
+
+	for _, test := range tests {
+		m, err := parseString(t, test.src)
+		values := []string{}
+		if len(m.Enums) > 0 {
+			for _, e := range m.Enums[0].Members {
+				values = append(values, fmt.Sprintf("%s=%d", e.Name, e.Value))
+			}
+		}
+		if got := strings.Join(values, " "); got != test.values {
+			t.Errorf("%q: got values %s, want %s", test.src, got, test.values)
+		}
+		if got := messages(t, err); got != test.err {
+			t.Errorf("%q: got errors %q, want %q", test.src, got, test.err)
+		}
+	}
+}
+
+func TestUnionLabels(t *testing.T) {
+	tests := []struct {
+		src    string
+		labels string
+		err    string
+	}{
+		{"union U switch (long) { case 1: long a; case 2: case 3: short b; default: char c; };", "a:1 b:2,3 c:default", ""},
+		{"const long N = 4; union U switch (short) { case N: long a; case N + 1: long b; };", "a:4 b:5", ""},
+		{"enum E { X, Y }; union U switch (E) { case X: long a; case Y: long b; };", "a:X b:Y", ""},
+		{"union U switch (char) { case 'a': long a; case 'b': long b; };", "a:'a' b:'b'", ""},
+		{"union U switch (boolean) { case TRUE: long a; case FALSE: long b; };", "a:TRUE b:FALSE", ""},
+		{"typedef unsigned short T; union U switch (T) { case 65535: long a; };", "a:65535", ""},
+		{"union U switch (long) { case 1: default: long a; };", "a:1,default", ""},
+
+		{"union U switch (long) { case 1: long a; case 1: long b; };", "a:1 b:1", "duplicate case label 1 in union U"},
+		{"union U switch (long) { case 1: case 1: long a; };", "a:1,1", "duplicate case label 1 in union U"},
+		{"union U switch (long) { default: long a; default: long b; };", "a:default b:default", "union U has more than one default member"},
+		{"union U switch (octet) { case 256: long a; };", "a:", "value 256 out of range for octet"},
+		{`union U switch (long) { case "x": long a; };`, "a:", `cannot use string value "x" as long`},
+		{"enum E { X }; enum F { Z }; union U switch (E) { case Z: long a; };", "a:", "enumerator Z is not a E"},
+		{"union U switch (double) { case 1: long a; };", "a:", "double cannot be the discriminant of a union"},
+		{"union U switch (string) { case 1: long a; };", "a:", "string cannot be the discriminant of a union"},
+		{"struct S { long x; }; union U switch (S) { case 1: long a; };", "a:", "struct S cannot be the type of a constant"},
+	}
+
+	for _, test := range tests {
+		m, err := parseString(t, test.src)
+		members := []string{}
+		if len(m.Unions) > 0 {
+			for _, um := range m.Unions[0].Members {
+				labels := []string{}
+				for _, l := range um.Labels {
+					if l.Value.Kind == ValueInvalid {
+						labels = append(labels, "")
+					} else {
+						labels = append(labels, l.Value.String())
+					}
+				}
+				if um.IsDefault {
+					labels = append(labels, "default")
+				}
+				members = append(members, um.MemberName+":"+strings.Join(labels, ","))
+			}
+		}
+		if got := strings.Join(members, " "); got != test.labels {
+			t.Errorf("%q: got labels %s, want %s", test.src, got, test.labels)
+		}
+		if got := messages(t, err); got != test.err {
+			t.Errorf("%q: got errors %q, want %q", test.src, got, test.err)
+		}
+	}
+}
+
+func TestBitmaskPositions(t *testing.T) {
+	tests := []struct {
+		src       string
+		positions string
+		err       string
+	}{
+		{"bitmask B { R, W, X };", "R=0 W=1 X=2", ""},
+		{"bitmask B { R, @position(4) W, X };", "R=0 W=4 X=5", ""},
+		{"const short P = 3; bitmask B { @position(P * 2) R };", "R=6", ""},
+		{"bitmask B { @position(31) R };", "R=31", ""},
+		{"@bit_bound(64) bitmask B { @position(63) R };", "R=63", ""},
+		{"@bit_bound(8) bitmask B { @position(7) R };", "R=7", ""},
+
+		{"bitmask B { @position(32) R };", "R=0", "value 32 out of range for bitmask B (positions 0 to 31)"},
+		{"bitmask B { @position(31) R, W };", "R=31 W=32", "position 32 of flag W out of range for bitmask B (positions 0 to 31)"},
+		{"@bit_bound(8) bitmask B { @position(8) R };", "R=0", "value 8 out of range for bitmask B (positions 0 to 7)"},
+		{"bitmask B { @position(-1) R };", "R=0", "value -1 out of range for bitmask B (positions 0 to 31)"},
+		{"bitmask B { R, @position(0) W };", "R=0 W=0", "flag W has the same position (0) as R"},
+		{"@bit_bound(65) bitmask B { R };", "R=0", "@bit_bound of a bitmask must be between 1 and 64"},
+		{"@bit_bound(0) bitmask B { R };", "R=0", "size must be positive: 0"},
+		{`bitmask B { @position("x") R };`, "R=0", `cannot use string value "x" as bitmask B (positions 0 to 31)`},
+	}
+
+	for _, test := range tests {
+		m, err := parseString(t, test.src)
+		positions := []string{}
+		if len(m.Bitmasks) > 0 {
+			for _, b := range m.Bitmasks[0].Members {
+				positions = append(positions, fmt.Sprintf("%s=%d", b.Name, b.Position))
+			}
+		}
+		if got := strings.Join(positions, " "); got != test.positions {
+			t.Errorf("%q: got positions %s, want %s", test.src, got, test.positions)
+		}
+		if got := messages(t, err); got != test.err {
+			t.Errorf("%q: got errors %q, want %q", test.src, got, test.err)
+		}
+	}
+}
+
+func TestBitsetFields(t *testing.T) {
+	tests := []struct {
+		src    string
+		fields string
+		err    string
+	}{
+		{"bitset S { bitfield<3> a; bitfield<5, octet> b, c; bitfield<2>; bitfield<1> d; };", "a:3@0:octet b:5@3:octet c:5@8:octet :2@13:octet d:1@15:boolean", ""},
+		{"bitset A { bitfield<4> a; }; bitset S : A { bitfield<16> b; };", "b:16@4:unsigned short", ""},
+		{"bitset S { bitfield<33> a; bitfield<1, boolean> b; };", "a:33@0:unsigned long long b:1@33:boolean", ""},
+
+		{"bitset S { bitfield<65> a; };", "a:65@0:", "bitfield width must be between 1 and 64"},
+		{"bitset S { bitfield<0> a; };", "a:0@0:", "size must be positive: 0"},
+		{"bitset S { bitfield<2, boolean> a; };", "a:2@0:boolean", "a boolean bitfield must be 1 bit wide"},
+		{"bitset S { bitfield<9, octet> a; };", "a:9@0:octet", "bitfield of 9 bits does not fit in octet"},
+		{"bitset S { bitfield<8, short> a; };", "a:8@0:short", ""},
+		{"bitset S { bitfield<4, float> a; };", "a:4@0:float", "float cannot hold a bitfield, which must be boolean, octet or an integer type"},
+		{"bitset S { bitfield<40> a; bitfield<40> b; };", "a:40@0:unsigned long long b:40@40:unsigned long long", "bitset S has 80 bits, more than 64"},
+		{"bitset S : Nope { bitfield<1> a; };", "a:1@0:boolean", "unknown bitset Nope"},
+		{"struct T { long x; }; bitset S : T { bitfield<1> a; };", "a:1@0:boolean", "struct T is not a bitset"},
+		{"bitset S : S { bitfield<1> a; };", "a:1@0:boolean", "bitset S extends itself"},
+	}
+
+	for _, test := range tests {
+		m, err := parseString(t, test.src)
+		fields := []string{}
+		if n := len(m.Bitsets); n > 0 {
+			for _, f := range m.Bitsets[n-1].Fields {
+				fields = append(fields, fmt.Sprintf("%s:%d@%d:%s", f.Name, f.Width.Value, f.Offset, f.Type))
+			}
+		}
+		if got := strings.Join(fields, " "); got != test.fields {
+			t.Errorf("%q: got fields %s, want %s", test.src, got, test.fields)
+		}
+		if got := messages(t, err); got != test.err {
+			t.Errorf("%q: got errors %q, want %q", test.src, got, test.err)
+		}
+	}
+}
+
+func TestRedefinitions(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"struct S; struct S { long a; };", ""},
+		{"struct S { long a; }; struct S;", ""},
+		{"struct S; struct S; struct S { long a; };", ""},
+		{"module M { struct A { long a; }; }; module M { struct B { long b; }; };", ""},
+		{"struct S { long a; }; module M { struct S { long b; }; };", ""},
+
+		{"struct S { long a; }; struct S { short b; };", "struct S is already declared"},
+		{"struct S; struct S { long a; }; struct S { short b; };", "struct S is already declared"},
+		{"module M { struct A { long a; }; }; module M { struct A { short b; }; };", "struct M::A is already declared"},
+		{"const long N = 1; const long N = 2;", "constant N is already declared"},
+		{"struct S { long a; }; const long S = 1;", "constant S is already declared"},
+		{"const long S = 1; struct S { long a; };", "struct S is already declared"},
+		{"struct S { long a; }; union S switch (long) { case 1: long a; };", "union S is already declared"},
+		{"interface I { }; struct I { long a; };", "struct I is already declared"},
+		{"enum E { A, B }; enum F { A };", "enumerator A is already declared"},
+		{"enum E { A, A };", "enumerator A is already declared"},
+		{"struct S; typedef long S;", "S is declared as struct, but defined as typedef"},
+	}
+
+	for _, test := range tests {
+		_, err := parseString(t, test.src)
+		if got := messages(t, err); got != test.err {
+			t.Errorf("%q: got errors %q, want %q", test.src, got, test.err)
+		}
+	}
+
+	// The error is at the second declaration, and points at the first.
+	_, err := parseString(t, "struct S { long a; };\nstruct S { short b; };")
+	d := diagnostics(t, err)
+	if len(d) != 1 || d[0].Code != CodeRedefinition || d[0].Pos.Line != 2 {
+		t.Fatalf("got %v, want a redefinition on line 2", err)
+	}
+	if len(d[0].Related) != 1 || d[0].Related[0].Pos.Line != 1 || d[0].Related[0].Msg != "struct S declared here" {
+		t.Errorf("got related information %v, want struct S declared on line 1", d[0].Related)
+	}
+}
+
+func TestAnnotationValues(t *testing.T) {
+	decls := `
+const long TEN = 10;
+@annotation Level { long level default 1; };
+@annotation Tag { string name; boolean on default TRUE; };
+@annotation Loose { any what; };
+module M { @annotation Inner { short n default TEN; }; };
+`
+	tests := []struct {
+		// Annotations applied to a struct S
+		anns   string
+		values string
+		err    string
+	}{
+		{"@Level", "level=1", ""},
+		{"@Level(5)", "level=5", ""},
+		{"@Level(level = TEN * 2)", "level=20", ""},
+		{`@Tag(name = "x")`, `name="x" on=TRUE`, ""},
+		{`@Tag(on = FALSE, name = "y")`, `name="y" on=FALSE`, ""},
+		{"@Loose(what = 3)", "what=3", ""},
+		{"@M::Inner", "n=10", ""},
+		{"@key @Level", "level=1", ""},
+
+		{`@Level("x")`, "", `cannot use string value "x" as long`},
+		{"@Level(level = 1, level = 2)", "level=1", "parameter level of @Level is given more than once"},
+		{"@Level(depth = 1)", "level=1", "annotation @Level has no parameter depth"},
+		{"@Tag", "on=TRUE", "@Tag needs a value for parameter name"},
+		{`@Tag("x")`, "", "parameters of @Tag must be given by name"},
+		{"@Tag(name = 1)", "on=TRUE", "cannot use integer value 1 as string"},
+		{"@Level(3000000000)", "", "value 3000000000 out of range for long"},
+		{"@Inner", "", ""},
+	}
+
+	for _, test := range tests {
+		m, err := parseString(t, decls+test.anns+" struct S { long x; };")
+		values := []string{}
+		if len(m.Structs) > 0 {
+			for _, a := range m.Structs[0].Annotations {
+				names := []string{}
+				for name := range a.Values {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				for _, name := range names {
+					values = append(values, name+"="+a.Values[name].String())
+				}
+			}
+		}
+		if got := strings.Join(values, " "); got != test.values {
+			t.Errorf("%q: got values %s, want %s", test.anns, got, test.values)
+		}
+		if got := messages(t, err); got != test.err {
+			t.Errorf("%q: got errors %q, want %q", test.anns, got, test.err)
+		}
+	}
+}
+
+func TestAnnotationDecls(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"enum Color { RED, GREEN }; @annotation Colored { Color color default GREEN; }; @Colored struct S { long x; };", ""},
+		{"@annotation A { long n default 1; long n; }; @A struct S { long x; };", "annotation @A has more than one parameter n\n@A needs a value for parameter n"},
+		{`@annotation A { long n default "x"; }; struct S { long x; };`, `cannot use string value "x" as long`},
+		{"@annotation A { octet n default 256; }; struct S { long x; };", "value 256 out of range for octet"},
+		{"@annotation A { Nope n; }; struct S { long x; };", "unknown type Nope"},
+	}
+
+	for _, test := range tests {
+		_, err := parseString(t, test.src)
+		if got := messages(t, err); got != test.err {
+			t.Errorf("%q: got errors %q, want %q", test.src, got, test.err)
+		}
+	}
+}
+
+func TestUnknownAnnotations(t *testing.T) {
+	src := "@nope @key struct S { @other long x; };"
+	tests := []struct {
+		policy   UnknownAnnotationPolicy
+		severity Severity
+		count    int
+	}{
+		{UnknownAnnotationWarn, SeverityWarning, 2},
+		{UnknownAnnotationIgnore, SeverityWarning, 0},
+		{UnknownAnnotationError, SeverityError, 2},
+	}
+
+	for _, test := range tests {
+		got := []*Diagnostic{}
+		opts := ParseOptions{
+			UnknownAnnotations: test.policy,
+			DiagnosticSink:     func(d *Diagnostic) { got = append(got, d) },
+		}
+		_, err := parseStringWithOptions(t, src, opts)
+		if len(got) != test.count {
+			t.Fatalf("%d: got %d diagnostics, want %d", test.policy, len(got), test.count)
+		}
+		for _, d := range got {
+			if d.Code != CodeUnknownAnnotation || d.Severity != test.severity {
+				t.Errorf("%d: got %s %s [%s], want %s [%s]", test.policy, d.Severity, d, d.Code, test.severity, CodeUnknownAnnotation)
+			}
+		}
+		if (err != nil) != (test.severity == SeverityError && test.count > 0) {
+			t.Errorf("%d: got error %v", test.policy, err)
+		}
+	}
+}
+
+func TestKeyAnnotations(t *testing.T) {
+	tests := []struct {
+		src string
+
+		// The annotations on each member of the first struct or union
+		members string
+		err     string
+	}{
+		{"struct S { long a; long b; long c; };\n#pragma keylist S a c\n", "a:@key b: c:@key", ""},
+		{"#pragma keylist S b\nstruct S { long a; long b; };\n", "a: b:@key", ""},
+		{"module M { struct S { long a; }; };\n#pragma keylist M::S a\n", "a:@key", ""},
+		{"module M { struct S { long a; };\n#pragma keylist S a\n};", "a:@key", ""},
+		{"struct S { long a, b; };\n#pragma keylist S b\n", "a: b:@key", ""},
+		{"struct S { @key long a; };\n#pragma keylist S a\n", "a:@key", ""},
+		{"struct S { long a; };\n#pragma keylist S\n", "a:", ""},
+		{"struct S {\n\tlong a; //@key\n\tlong b; //@ID 5\n\tlong c; // @key is not read here\n};", "a:@key b:@id(5) c:", ""},
+		{"struct S {\n\tlong a; //@Key\n\t@key long b; //@key\n\t@id(1) long c; //@ID 2\n};", "a:@key b:@key c:@id(1)", ""},
+		{"union U switch (long) {\n\tcase 1: long a; //@key\n};", "a:@key", ""},
+
+		{"struct S { long a; };\n#pragma keylist S nope\n", "a:", "struct S has no member nope"},
+		{"#pragma keylist Nope a\n", "", "unknown type in keylist: Nope"},
+		{"enum E { A };\n#pragma keylist E A\n", "", "E in keylist is not a struct"},
+		{"#pragma keylist\n", "", "expected type name in keylist"},
+		{"#pragma keylist S 1\nstruct S { long a; };", "a:", "expected key member name in keylist"},
+		{"struct S {\n\tlong a; //@ID x\n};", "a:", "expected an integer in //@ID x"},
+	}
+
+	for _, test := range tests {
+		m, err := parseString(t, test.src)
+		members := []string{}
+		add := func(name string, anns Annotations) {
+			s := []string{}
+			for _, a := range anns {
+				s = append(s, a.String())
+			}
+			members = append(members, name+":"+strings.Join(s, " "))
+		}
+		structs, unions := m.Structs, m.Unions
+		if len(m.Modules) > 0 {
+			structs = m.Modules[0].Structs
+		}
+		if len(structs) > 0 {
+			for _, member := range structs[0].Members {
+				add(member.Name, member.Annotations)
+			}
+		} else if len(unions) > 0 {
+			for _, member := range unions[0].Members {
+				add(member.MemberName, member.Annotations)
+			}
+		}
+		if got := strings.Join(members, " "); got != test.members {
+			t.Errorf("%q: got members %s, want %s", test.src, got, test.members)
+		}
+		if got := messages(t, err); got != test.err {
+			t.Errorf("%q: got errors %q, want %q", test.src, got, test.err)
+		}
+	}
+}
+
+func TestArrayDimensions(t *testing.T) {
+	tests := []struct {
+		src  string
+		dims string
+		err  string
+	}{
+		{"struct S { long a[3]; };", "3", ""},
+		{"struct S { long a[2][3][4]; };", "2 3 4", ""},
+		{"struct S { long a[N][N * 2]; }; const long N = 2;", "2 4", ""},
+		{"module M { const short N = 5; }; struct S { long a[M::N][(1 << 3) - 1]; };", "5 7", ""},
+		{"typedef long T[2][N]; const unsigned long N = 6;", "2 6", ""},
+		{"struct S { sequence<long> a[2]; };", "2", ""},
+
+		{"struct S { long a[0]; };", "0", "size must be positive: 0"},
+		{"struct S { long a[2][-1]; };", "2 0", "value -1 out of range for unsigned long"},
+		{"struct S { long a[Nope]; };", "0", "unknown name Nope"},
+		{`struct S { long a["x"]; };`, "0", `cannot use string value "x" as unsigned long`},
+		{"struct S { long a[1.5]; };", "0", "cannot use floating point value 1.5 as unsigned long"},
+		{"struct S { long a[3; };", "", "expected close bracket"},
+	}
+
+	for _, test := range tests {
+		m, err := parseString(t, test.src)
+		var typ *Type
+		if len(m.Structs) > 0 && len(m.Structs[0].Members) > 0 {
+			typ = &m.Structs[0].Members[0].Type
+		} else if len(m.TypeDefs) > 0 {
+			typ = &m.TypeDefs[0].Type
+		}
+		dims := []string{}
+		if typ != nil {
+			for _, d := range typ.Dimensions {
+				dims = append(dims, fmt.Sprint(d.Value))
+			}
+		}
+		if got := strings.Join(dims, " "); got != test.dims {
+			t.Errorf("%q: got dimensions %s, want %s", test.src, got, test.dims)
+		}
+		if got := messages(t, err); got != test.err {
+			t.Errorf("%q: got errors %q, want %q", test.src, got, test.err)
+		}
+	}
+}
+
+func TestForwardDecls(t *testing.T) {
+	tests := []struct {
+		src string
+
+		// Each forward declaration, and what it is linked to
+		links string
+		err   string
+	}{
+		{"struct S; struct S { long a; };", "struct S:struct", ""},
+		{"union U; union U switch (long) { case 1: long a; };", "union U:union", ""},
+		{"interface I; interface I { void f(); };", "interface I:interface", ""},
+		{"struct S; struct T { sequence<S> s; }; struct S { T t; };", "struct S:struct", ""},
+		{"struct S { long a; }; struct S;", "struct S:struct", ""},
+		{"struct S; struct S; struct S { long a; };", "struct S:struct struct S:struct", ""},
+		{"module M { struct S; }; module M { struct S { long a; }; };", "struct S:struct", ""},
+
+		{"struct S;", "struct S:", "struct S is declared, but never defined"},
+		{"module M { union U; };", "union U:", "union U is declared, but never defined"},
+		{"struct S; union S switch (long) { case 1: long a; };", "struct S:", "S is declared as struct, but defined as union"},
+		{"interface I; struct I { long a; };", "interface I:", "I is declared as interface, but defined as struct"},
+	}
+
+	for _, test := range tests {
+		m, err := parseString(t, test.src)
+		links := []string{}
+		decls := m.ForwardDecls
+		for _, mod := range m.Modules {
+			decls = append(decls, mod.ForwardDecls...)
+		}
+		for _, fd := range decls {
+			to := ""
+			switch {
+			case fd.Struct != nil:
+				to = "struct"
+			case fd.Union != nil:
+				to = "union"
+			case fd.Interface != nil:
+				to = "interface"
+			}
+			links = append(links, fmt.Sprintf("%s %s:%s", fd.Kind, fd.Name, to))
+		}
+		if got := strings.Join(links, " "); got != test.links {
+			t.Errorf("%q: got %s, want %s", test.src, got, test.links)
+		}
+		if got := messages(t, err); got != test.err {
+			t.Errorf("%q: got errors %q, want %q", test.src, got, test.err)
+		}
+	}
+
+	// A forward declaration is linked to the very definition.
+	m, _ := parseString(t, "struct S; struct S { long a; };")
+	if m.ForwardDecls[0].Struct != &m.Structs[0] {
+		t.Errorf("forward declaration of S is not linked to its definition")
+	}
+}
+
+func TestMapTypes(t *testing.T) {
+	tests := []struct {
+		// Declarations, the last of which is a typedef of a map
+		src  string
+		want string
+		err  string
+	}{
+		{"typedef map<string, long> M;", "string long 0", ""},
+		{"typedef map<long long, sequence<string>, 16> M;", "long long sequence<string> 16", ""},
+		{"const long N = 4; typedef map<wstring<8>, double, N * 2> M;", "wstring<8> double 8", ""},
+		{"typedef unsigned short K; typedef K K2; typedef map<K2, long> M;", "K2 long 0", ""},
+		{"typedef string<4> K; typedef map<K, map<octet, K>> M;", "K map<octet, K> 0", ""},
+
+		{"typedef map<double, long> M;", "double long 0", "double cannot be the key of a map, which must be an integer or string type"},
+		{"enum E { A }; typedef map<E, long> M;", "E long 0", "E cannot be the key of a map, which must be an integer or string type"},
+		{"typedef map<boolean, long> M;", "boolean long 0", "boolean cannot be the key of a map, which must be an integer or string type"},
+		{"struct S { long a; }; typedef map<S, long> M;", "S long 0", "S cannot be the key of a map, which must be an integer or string type"},
+		{"typedef float F; typedef map<F, long> M;", "F long 0", "F cannot be the key of a map, which must be an integer or string type"},
+		{"typedef long A[2]; typedef map<A, long> M;", "A long 0", "A cannot be the key of a map, which must be an integer or string type"},
+		{"typedef map<Nope, long> M;", "Nope long 0", "unknown type Nope"},
+		{"typedef map<string, long, 0> M;", "string long 0", "size must be positive: 0"},
+		{"typedef map<string> M;", "", "expected key and value types in map"},
+	}
+
+	for _, test := range tests {
+		m, err := parseString(t, test.src)
+		got := ""
+		if n := len(m.TypeDefs); n > 0 {
+			if key, value, ok := m.TypeDefs[n-1].Type.MapTypes(); ok {
+				bound, _ := m.TypeDefs[n-1].Type.MapBound()
+				got = fmt.Sprintf("%s %s %d", key, value, bound)
+			}
+		}
+		if got != test.want {
+			t.Errorf("%q: got %q, want %q", test.src, got, test.want)
+		}
+		if got := messages(t, err); got != test.err {
+			t.Errorf("%q: got errors %q, want %q", test.src, got, test.err)
+		}
+	}
+
+	// Other types are not maps.
+	if _, _, ok := (Type{Kind: TypeSequence}).MapTypes(); ok {
+		t.Errorf("a sequence has map types")
+	}
+	if _, ok := (Type{Kind: TypeSequence}).MapBound(); ok {
+		t.Errorf("a sequence has a map bound")
+	}
+}
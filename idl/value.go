@@ -0,0 +1,143 @@
+package idl
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// ValueKind describes what kind of data a Value holds.
+type ValueKind int
+
+const (
+	// ValueInvalid is the zero Value, which holds nothing.
+	ValueInvalid ValueKind = iota
+
+	// ValueInteger is an integer, held in Value.Int.
+	ValueInteger
+
+	// ValueFloat is a floating point number, held in Value.Float.
+	ValueFloat
+
+	// ValueFixed is a fixed point number, held in Value.Fixed, with
+	// Value.Scale digits after the decimal point.
+	ValueFixed
+
+	// ValueString is a string, held in Value.Str.
+	ValueString
+
+	// ValueBoolean is TRUE or FALSE, held in Value.Bool.
+	ValueBoolean
+
+	// ValueWString is a wide string, held in Value.Str.
+	ValueWString
+
+	// ValueChar is a character, held in Value.Char.
+	ValueChar
+
+	// ValueWChar is a wide character, held in Value.Char.
+	ValueWChar
+
+	// ValueEnumerator is an enumerator, named by Value.Str (fully scoped,
+	// e.g. "Mod::RED"). Its value, as given by @value or = or counted on
+	// from the one before, is held in Value.Int.
+	ValueEnumerator
+)
+
+// Turn a ValueKind into a string.
+func (k ValueKind) String() string {
+	switch k {
+	case ValueInvalid:
+		return "invalid"
+	case ValueInteger:
+		return "integer"
+	case ValueFloat:
+		return "floating point"
+	case ValueFixed:
+		return "fixed point"
+	case ValueString:
+		return "string"
+	case ValueBoolean:
+		return "boolean"
+	case ValueWString:
+		return "wide string"
+	case ValueChar:
+		return "character"
+	case ValueWChar:
+		return "wide character"
+	case ValueEnumerator:
+		return "enumerator"
+	}
+	return fmt.Sprintf("ValueKind(%d)", int(k))
+}
+
+// Value is a typed constant value, such as the value of a literal.
+type Value struct {
+	// What kind of data the value holds
+	Kind ValueKind
+
+	// The value of an integer. Integers are kept at arbitrary precision, so
+	// that any IDL integer type (up to unsigned long long) fits.
+	Int *big.Int
+
+	// The value of a floating point number
+	Float float64
+
+	// The value of a fixed point number
+	Fixed *big.Rat
+
+	// The number of digits after the decimal point of a fixed point number
+	Scale int
+
+	// The value of a string or wide string, or the name of an enumerator
+	Str string
+
+	// The value of a character or wide character
+	Char rune
+
+	// The value of a boolean
+	Bool bool
+}
+
+// Turn a Value into a string, written as it would be in IDL.
+func (v Value) String() string {
+	switch v.Kind {
+	case ValueInteger:
+		return v.Int.String()
+	case ValueFloat:
+		return strconv.FormatFloat(v.Float, 'g', -1, 64)
+	case ValueFixed:
+		return v.Fixed.FloatString(v.Scale) + "d"
+	case ValueString:
+		return strconv.Quote(v.Str)
+	case ValueWString:
+		return "L" + strconv.Quote(v.Str)
+	case ValueChar:
+		return strconv.QuoteRune(v.Char)
+	case ValueWChar:
+		return "L" + strconv.QuoteRune(v.Char)
+	case ValueBoolean:
+		if v.Bool {
+			return "TRUE"
+		}
+		return "FALSE"
+	case ValueEnumerator:
+		return v.Str
+	}
+	return "(invalid)"
+}
+
+// Negate returns -v, for numeric values.
+func (v Value) Negate() (Value, error) {
+	switch v.Kind {
+	case ValueInteger:
+		v.Int = new(big.Int).Neg(v.Int)
+	case ValueFloat:
+		v.Float = -v.Float
+	case ValueFixed:
+		v.Fixed = new(big.Rat).Neg(v.Fixed)
+	default:
+		return Value{}, fmt.Errorf("cannot negate %s value", v.Kind)
+	}
+	return v, nil
+}